@@ -1,20 +1,93 @@
 package main
 
 import (
+	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
 
+	"wingbox.spencrc/internal/auth"
+	"wingbox.spencrc/internal/env"
+	"wingbox.spencrc/internal/middleware"
 	"wingbox.spencrc/internal/server"
+	"wingbox.spencrc/internal/tracing"
 )
 
+type config struct {
+	Server server.Config
+	Port   uint64 `env:"PORT" default:"3001"`
+	// the auth service's published keys, e.g. http://auth:3002/.well-known/jwks.json, when it signs with ES256
+	JWKSURL string `env:"JWKS_URL"`
+	// otherwise, the secret it signs with HS256
+	JWTSecret env.Secret `env:"JWT_SECRET"`
+	// or, if it signs with an HS256 keyring, the same keyring, so tokens signed with a newly activated key aren't refused. see auth.KEYRING_MANIFEST
+	JWTKeyringDir string `env:"JWT_KEYRING_DIR"`
+}
+
+func (c config) Validate() error {
+	if c.JWKSURL == "" && c.JWTSecret == "" && c.JWTKeyringDir == "" {
+		return fmt.Errorf("JWT_SECRET %w unless JWKS_URL or JWT_KEYRING_DIR is set", env.ErrMissing)
+	}
+	return nil
+}
+
 func home(w http.ResponseWriter, r *http.Request) {
 	
 }
 
+// Verifies access tokens against the auth service's JWKS if JWKS_URL is set (ES256), otherwise with every secret in the keyring at
+// JWT_KEYRING_DIR, or JWT_SECRET (HS256)
+func newVerifier(s *server.Server, cfg config) *middleware.TokenVerifier {
+	switch {
+	case cfg.JWKSURL != "":
+		return middleware.NewJWKSVerifier(cfg.JWKSURL, tracing.NewHTTPClient(5*time.Second))
+	case cfg.JWTKeyringDir != "":
+		secrets, err := auth.KeyringSecrets(cfg.JWTKeyringDir, time.Now())
+		if err != nil {
+			s.LogFatal("could not load JWT keyring", "err", err)
+		}
+		var current atomic.Pointer[[][]byte]
+		current.Store(&secrets)
+
+		// reloaded like auth reloads its signing keys, so a staged key is accepted before auth starts signing with it, and retired keys
+		// stop being accepted
+		auth.WatchKeyring(s, time.Minute, func() error {
+			secrets, err := auth.KeyringSecrets(cfg.JWTKeyringDir, time.Now())
+			if err != nil {
+				return err
+			}
+			current.Store(&secrets)
+			return nil
+		})
+		return middleware.NewHMACVerifierFunc(func() [][]byte { return *current.Load() })
+	default:
+		return middleware.NewHMACVerifier([]byte(cfg.JWTSecret.Reveal()))
+	}
+}
+
 func main() {
-	s := server.Init()
+	var cfg config
+	if err := env.Load(&cfg); err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		server.Healthcheck(cfg.Port)
+	}
+
+	s := server.Init(cfg.Server)
+	s.Logger.Info("Loaded configuration", "config", cfg)
+
+	// checked here too, not just by nginx, so requests straight to the api can't skip it
+	verifier := newVerifier(s, cfg)
+	authChain := append(slices.Clone(s.BaseChain), middleware.RequireAuth(verifier))
+	s.AddReadinessCheck("jwks", verifier.Ready)
 
 	// Register home function as route (handler) for "/" page
-	s.Handle("/", s.BaseChain.ThenFunc(home))	
+	s.Handle("/", authChain.ThenFunc(home))
 
-	s.Listen(3001)
-}
\ No newline at end of file
+	s.Listen(cfg.Port)
+}
@@ -1,11 +1,25 @@
 package main
 
 import (
+	"log"
+	"os"
+
 	"wingbox.spencrc/internal/auth"
+	"wingbox.spencrc/internal/env"
+	"wingbox.spencrc/internal/server"
 )
 
 func main() {
-	as := auth.NewAuthService()
+	var cfg auth.Config
+	if err := env.Load(&cfg); err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		server.Healthcheck(cfg.Port)
+	}
+
+	as := auth.NewAuthService(cfg)
 	as.RegisterRoutes()
-	as.Listen(3002)
+	as.Listen(cfg.Port)
 }
@@ -1,50 +1,185 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	_ "modernc.org/sqlite"
+	"wingbox.spencrc/internal/env"
+	"wingbox.spencrc/internal/migrate"
 )
 
-type Migration struct {
-	name string
-	sql string
+const usage = `usage: migrator [--dry-run] <command>
+
+commands:
+  up              apply every pending migration (default)
+  down N          roll back the N newest migrations
+  goto VERSION    apply or roll back migrations until the schema is at VERSION (0 rolls back everything)
+  redo            roll back the newest migration, then apply it again
+  status          show which migrations are applied
+`
+
+// flag stops parsing at the first positional argument, so this pulls flags out from between them too (e.g. "down 1 --dry-run")
+func parseArgs() []string {
+	flag.Parse()
+	args := flag.Args()
+
+	var positional []string
+	for len(args) > 0 {
+		if strings.HasPrefix(args[0], "-") {
+			flag.CommandLine.Parse(args)
+			args = flag.Args()
+			continue
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	return positional
+}
+
+// Parses the command's single numeric argument, e.g. N in "down N"
+func parseNumber(args []string) int {
+	if len(args) != 2 {
+		log.Fatalf("%s takes exactly one number\n\n%s", args[0], usage)
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		log.Fatalf("%s takes exactly one number, got %q\n\n%s", args[0], args[1], usage)
+	}
+	return n
+}
+
+// Prints the ledger next to the migration files, including anything pending, edited, or missing
+func printStatus(applied []migrate.AppliedMigration, migrations []migrate.Migration) {
+	appliedByVersion := map[int]migrate.AppliedMigration{}
+	for _, a := range applied {
+		appliedByVersion[a.Version] = a
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, m := range migrations {
+		a, ok := appliedByVersion[m.Version]
+		switch {
+		case !ok:
+			fmt.Fprintf(tw, "%04d\t%s\tpending\t\n", m.Version, m.Name)
+		case a.Checksum != m.Checksum:
+			fmt.Fprintf(tw, "%04d\t%s\tEDITED SINCE APPLIED\t%s\n", m.Version, m.Name, a.AppliedAt.Format(time.RFC3339))
+		default:
+			fmt.Fprintf(tw, "%04d\t%s\tapplied\t%s\n", m.Version, m.Name, a.AppliedAt.Format(time.RFC3339))
+		}
+		delete(appliedByVersion, m.Version)
+	}
+	for _, a := range applied {
+		if _, ok := appliedByVersion[a.Version]; ok {
+			fmt.Fprintf(tw, "%04d\t%s\tMISSING FILE\t%s\n", a.Version, a.Name, a.AppliedAt.Format(time.RFC3339))
+		}
+	}
+	tw.Flush()
+
+	fmt.Printf("\nschema is at version %04d, latest is %04d\n", migrate.Current(applied), migrate.Latest(migrations))
+}
+
+// Prints what the plan is about to do. With dry run, the SQL each step would run is printed too.
+func printPlan(plan []migrate.Step, dryRun bool) {
+	if len(plan) == 0 {
+		fmt.Println("nothing to do, database is already up to date")
+		return
+	}
+
+	fmt.Println("plan:")
+	for _, step := range plan {
+		fmt.Printf("  %s\n", step)
+	}
+
+	if dryRun {
+		for _, step := range plan {
+			fmt.Printf("\n-- %s\n%s", step, step.SQL())
+		}
+	}
+}
+
+type config struct {
+	// same as server.Config's, which isn't used here as the migrator must run without foreign keys enforced: migrations that rebuild a table
+	// would otherwise cascade its deletion into every table that references it
+	DBPath string `env:"DB_PATH" default:"/db/app.db"`
 }
 
 func main() {
-	const DB_PATH = "/db/app.db"
+	var cfg config
+	if err := env.Load(&cfg); err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
 
-	db, err := sql.Open("sqlite", DB_PATH)
+	dryRun := flag.Bool("dry-run", false, "print the plan and the SQL it would run, without running it")
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
+	args := parseArgs()
+	if len(args) == 0 {
+		args = []string{"up"}
+	}
+
+	db, err := sql.Open("sqlite", cfg.DBPath)
 	if err != nil {
 		log.Fatal("Failed to open sqlite database: ", err)
 	}
 	defer db.Close()
 
-	migrations := []Migration {
-		{
-			name: "users",
-			sql: `
-			CREATE TABLE IF NOT EXISTS users (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				discord_id TEXT UNIQUE NOT NULL
-			);`,
-		},
-		{
-			name: "refresh_tokens",
-			sql: `
-			CREATE TABLE IF NOT EXISTS refresh_tokens (
-				jti TEXT PRIMARY KEY,
-				sub TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-				expires_at INTEGER NOT NULL
-			);`,
-		},
+	ctx := context.Background()
+
+	migrations, err := migrate.Migrations()
+	if err != nil {
+		log.Fatal("failed to load migrations: ", err)
 	}
 
-	for _, m := range migrations {
-		_, err := db.Exec(m.sql)
-		if err != nil {
-			log.Fatalf("failed to perform migration %s: %v", m.name, err)
-		}
+	applied, err := migrate.Applied(ctx, db)
+	if err != nil {
+		log.Fatal("failed to read applied migrations: ", err)
+	}
+
+	if args[0] == "status" {
+		printStatus(applied, migrations)
+		return
 	}
-}
\ No newline at end of file
+
+	if err = migrate.Verify(applied, migrations); err != nil {
+		log.Fatalf("refusing to migrate: %v", err)
+	}
+
+	var plan []migrate.Step
+	switch args[0] {
+	case "up":
+		plan = migrate.PlanUp(applied, migrations)
+	case "down":
+		plan, err = migrate.PlanDown(applied, migrations, parseNumber(args))
+	case "goto":
+		plan, err = migrate.PlanGoto(applied, migrations, parseNumber(args))
+	case "redo":
+		plan, err = migrate.PlanRedo(applied, migrations)
+	default:
+		log.Fatalf("unknown command %q\n\n%s", args[0], usage)
+	}
+	if err != nil {
+		log.Fatal("failed to plan migrations: ", err)
+	}
+
+	printPlan(plan, *dryRun)
+	if *dryRun || len(plan) == 0 {
+		return
+	}
+
+	done, err := migrate.Run(ctx, db, plan)
+	for _, step := range done {
+		log.Printf("done: %s", step)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
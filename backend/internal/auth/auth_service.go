@@ -1,83 +1,143 @@
 package auth
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"sync/atomic"
+	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	jwtcookie "github.com/stfsy/go-jwt-cookie"
-	shared "wingbox.spencrc/internal/env"
 	"wingbox.spencrc/internal/server"
 )
 
 type AuthService struct {
 	server *server.Server
-	redirectURI string
-	clientId string
-	clientSecret string
-	accessMgr *jwtcookie.CookieManager
-	refreshMgr *jwtcookie.CookieManager
+	providers map[string]Provider
+	// swapped out whenever the signing keys are reloaded. see reloadSigningKeys
+	tokenMgrs atomic.Pointer[tokenManagers]
+	cfg Config
+	metrics *authMetrics
 }
 
-func newAccessManager(jwtKey []byte, jwtSalt []byte) (*jwtcookie.CookieManager, error) {
-	return jwtcookie.NewCookieManager(
+// The cookie managers for every kind of token we sign, all using the same keys
+type tokenManagers struct {
+	keys signingKeys
+	access *jwtcookie.CookieManager
+	refresh *jwtcookie.CookieManager
+	flow *jwtcookie.CookieManager
+}
+
+func newTokenManagers(keys signingKeys, cfg Config) (*tokenManagers, error) {
+	access, err := newAccessManager(keys, cfg.AccessTokenTTL)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize access token cookie manager: %w", err)
+	}
+	refresh, err := newRefreshManager(keys, cfg.RefreshTokenTTL)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize refresh token cookie manager: %w", err)
+	}
+	flow, err := newFlowManager(keys)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize oauth flow cookie manager: %w", err)
+	}
+	return &tokenManagers{keys, access, refresh, flow}, nil
+}
+
+func (as *AuthService) managers() *tokenManagers {
+	return as.tokenMgrs.Load()
+}
+
+func (as *AuthService) accessMgr() *jwtcookie.CookieManager {
+	return as.managers().access
+}
+
+func (as *AuthService) refreshMgr() *jwtcookie.CookieManager {
+	return as.managers().refresh
+}
+
+func (as *AuthService) flowMgr() *jwtcookie.CookieManager {
+	return as.managers().flow
+}
+
+func newAccessManager(keys signingKeys, maxAge time.Duration) (*jwtcookie.CookieManager, error) {
+	return jwtcookie.NewCookieManager(append(keys.cookieOptions(),
 		jwtcookie.WithHTTPOnly(true),
 		jwtcookie.WithSecure(true),
-		jwtcookie.WithSigningKeyHMAC(
-			jwtKey,
-			jwtSalt,
-		),
-		jwtcookie.WithValidationKeysHMAC([][]byte{jwtKey}),
-		jwtcookie.WithSigningMethod(jwt.SigningMethodHS256),
-		jwtcookie.WithMaxAge(ACCESS_MAX_AGE),
+		jwtcookie.WithMaxAge(int(maxAge.Seconds())),
 		jwtcookie.WithIssuer("auth"),
-		jwtcookie.WithAudience("wingbox"),
+		jwtcookie.WithAudience(ACCESS_TOKEN_AUDIENCE),
 		jwtcookie.WithSameSite(http.SameSiteLaxMode),
-		jwtcookie.WithCookieName("__Http-DO_NOT_SHARE-access_token"),
-	)
+		jwtcookie.WithCookieName(ACCESS_COOKIE_NAME),
+	)...)
 }
 
-func newRefreshManager(jwtKey []byte, jwtSalt []byte) (*jwtcookie.CookieManager, error) {
-	return jwtcookie.NewCookieManager(
+func newRefreshManager(keys signingKeys, maxAge time.Duration) (*jwtcookie.CookieManager, error) {
+	return jwtcookie.NewCookieManager(append(keys.cookieOptions(),
 		jwtcookie.WithHTTPOnly(true),
-		jwtcookie.WithSigningKeyHMAC(
-			jwtKey,
-			jwtSalt,
-		),
-		jwtcookie.WithValidationKeysHMAC([][]byte{jwtKey}),
-		jwtcookie.WithSigningMethod(jwt.SigningMethodHS256),
-		jwtcookie.WithMaxAge(REFRESH_MAX_AGE), // 1 month
+		jwtcookie.WithMaxAge(int(maxAge.Seconds())),
 		jwtcookie.WithIssuer("auth"),
-		jwtcookie.WithAudience("wingbox"),
+		// so a refresh token can never pass for an access token, which would outlive logging out, or the other way around
+		jwtcookie.WithAudience("wingbox-refresh"),
 		jwtcookie.WithSameSite(http.SameSiteLaxMode),
-		jwtcookie.WithCookieName("__Http-DO_NOT_SHARE-refresh_token"),
-	)
+		jwtcookie.WithCookieName(REFRESH_COOKIE_NAME),
+	)...)
 }
 
-func NewAuthService() *AuthService {
-	s := server.Init()
-	clientId := shared.Ensureenv("DISCORD_CLIENT_ID")
-	clientSecret := shared.Ensureenv("DISCORD_CLIENT_SECRET")
-	redirectURI := shared.Ensureenv("REDIRECT_URI")
-
-	jwtKey := []byte(shared.Ensureenv("JWT_SECRET"))
-	jwtSalt := []byte(shared.Ensureenv("JWT_SALT"))
+// Sets up the auth service from cfg, which should have been loaded (and so validated) by env.Load
+func NewAuthService(cfg Config) *AuthService {
+	s := server.Init(cfg.Server)
+	// secrets are env.Secrets, so they're redacted
+	s.Logger.Info("Loaded configuration", "config", cfg)
+	providers := loadProviders(cfg)
 
-	accessMgr, err := newAccessManager(jwtKey, jwtSalt)
+	keys, err := loadSigningKeys(cfg.Signing)
 	if err != nil {
-		s.LogFatal("could not initialize access token cookie manager", "err", err)
+		s.LogFatal("could not load JWT signing keys", "err", err)
 	}
-
-	refreshMgr, err := newRefreshManager(jwtKey, jwtSalt)
+	managers, err := newTokenManagers(keys, cfg)
 	if err != nil {
-		s.LogFatal("could not initialize refresh token cookie manager", "err", err)
+		s.LogFatal("could not set up token signing", "err", err)
+	}
+
+	as := &AuthService{server: s, providers: providers, cfg: cfg, metrics: newAuthMetrics(s.Metrics)}
+	as.tokenMgrs.Store(managers)
+
+	if cfg.Signing.KeyringDir != "" {
+		WatchKeyring(s, time.Minute, as.reloadSigningKeys)
 	}
+	s.AddReadinessCheck("signing", func(context.Context) error {
+		return as.managers().keys.checkActive(time.Now())
+	})
+
+	return as
+}
 
-	return &AuthService{s, redirectURI, clientId, clientSecret, accessMgr, refreshMgr}
+// Swaps in freshly loaded signing keys, keeping the current ones if they can't be loaded. see WatchKeyring
+func (as *AuthService) reloadSigningKeys() error {
+	keys, err := loadSigningKeys(as.cfg.Signing)
+	if err != nil {
+		return err
+	}
+	managers, err := newTokenManagers(keys, as.cfg)
+	if err != nil {
+		return fmt.Errorf("could not set up token signing with reloaded keys: %w", err)
+	}
+	as.tokenMgrs.Store(managers)
+	return nil
 }
 
 func (as *AuthService) RegisterRoutes() {
-	as.server.Handle("/discord", as.server.BaseChain.ThenFunc(as.Discord))
-	as.server.Handle("/redirect", as.server.BaseChain.ThenFunc(as.Redirect))
+	as.server.Handle("/login/{provider}", as.server.BaseChain.Then(server.HandlerFunc(as.Login)))
+	as.server.Handle("/callback/{provider}", as.server.BaseChain.Then(server.HandlerFunc(as.Redirect)))
+	as.server.Handle("GET /link/{provider}", as.server.BaseChain.Then(server.HandlerFunc(as.Link)))
+	as.server.Handle("GET /identities", as.server.BaseChain.Then(server.HandlerFunc(as.Identities)))
+	as.server.Handle("DELETE /identities/{provider}/{subject}", as.server.BaseChain.Then(server.HandlerFunc(as.Unlink)))
+	as.server.Handle("/verify", as.server.BaseChain.Then(server.HandlerFunc(as.Verify)))
+	as.server.Handle("GET /.well-known/jwks.json", as.server.BaseChain.Then(server.HandlerFunc(as.JWKS)))
+	as.server.Handle("POST /refresh", as.server.BaseChain.Then(server.HandlerFunc(as.Refresh)))
+	as.server.Handle("POST /logout", as.server.BaseChain.Then(server.HandlerFunc(as.Logout)))
+	as.server.Handle("POST /logout/all", as.server.BaseChain.Then(server.HandlerFunc(as.LogoutAll)))
 }
 
 func (as *AuthService) Listen(port uint64) {
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"wingbox.spencrc/internal/env"
+	"wingbox.spencrc/internal/server"
+)
+
+// Names of the providers that have their own code, which OIDC_NAME can't take. see loadProviders
+var builtinProviders = []string{"discord", "github"}
+
+var ErrNoProviders error = errors.New("no login providers are configured, set at least one of DISCORD_CLIENT_ID, GITHUB_CLIENT_ID or OIDC_CLIENT_ID")
+
+// The auth service's configuration, filled from the environment by env.Load
+type Config struct {
+	Server server.Config
+	Port   uint64 `env:"PORT" default:"3002"`
+
+	// providers redirect back to <OAUTH_REDIRECT_BASE_URL>/<provider> after login, e.g. https://example.com/auth/callback
+	RedirectBaseURL string `env:"OAUTH_REDIRECT_BASE_URL" required:"true"`
+	// paths users may be sent back to after logging in, e.g. "/app,/settings". see safeReturnTo
+	ReturnToPrefixes []string `env:"OAUTH_RETURN_TO_PREFIXES" default:"/"`
+	// a provider is only enabled if its client ID is set
+	Discord OAuthClientConfig `envPrefix:"DISCORD_"`
+	GitHub  OAuthClientConfig `envPrefix:"GITHUB_"`
+	OIDC    OIDCConfig        `envPrefix:"OIDC_"`
+
+	Signing SigningConfig
+
+	AccessTokenTTL  time.Duration `env:"ACCESS_TOKEN_TTL" default:"3m"`
+	RefreshTokenTTL time.Duration `env:"REFRESH_TOKEN_TTL" default:"720h"`
+}
+
+type OAuthClientConfig struct {
+	ClientID     string     `env:"CLIENT_ID"`
+	ClientSecret env.Secret `env:"CLIENT_SECRET"`
+}
+
+// Any OpenID Connect provider, found through OIDC_ISSUER's discovery document
+type OIDCConfig struct {
+	Client OAuthClientConfig
+	// also the name of the provider in its routes, e.g. /login/oidc
+	Name   string `env:"NAME" default:"oidc"`
+	Scopes string `env:"SCOPES" default:"openid"`
+	Issuer string `env:"ISSUER"`
+}
+
+// How tokens are signed. see loadSigningKeys
+type SigningConfig struct {
+	// ignored if KeyringDir is set, as the keyring says which algorithm it uses
+	Alg            string     `env:"JWT_SIGNING_ALG" default:"HS256" oneof:"HS256|ES256"`
+	Secret         env.Secret `env:"JWT_SECRET"`
+	Salt           env.Secret `env:"JWT_SALT"`
+	PrivateKeyPath string     `env:"JWT_PRIVATE_KEY_PATH"`
+	// with an HS256 keyring, give the api the same JWT_KEYRING_DIR, or it refuses tokens signed with a newly activated key
+	KeyringDir string `env:"JWT_KEYRING_DIR"`
+}
+
+func missing(name string, when string) error {
+	return fmt.Errorf("%s %w %s", name, env.ErrMissing, when)
+}
+
+func (c Config) Validate() error {
+	var errs []error
+
+	clients := []struct {
+		prefix string
+		client OAuthClientConfig
+	}{{"DISCORD", c.Discord}, {"GITHUB", c.GitHub}, {"OIDC", c.OIDC.Client}}
+	enabled := 0
+	for _, p := range clients {
+		if p.client.ClientID == "" {
+			continue
+		}
+		enabled++
+		if p.client.ClientSecret == "" {
+			errs = append(errs, missing(p.prefix+"_CLIENT_SECRET", "when "+p.prefix+"_CLIENT_ID is set"))
+		}
+	}
+	if enabled == 0 {
+		errs = append(errs, ErrNoProviders)
+	}
+	if c.OIDC.Client.ClientID != "" && c.OIDC.Issuer == "" {
+		errs = append(errs, missing("OIDC_ISSUER", "when OIDC_CLIENT_ID is set"))
+	}
+	// identities are keyed by provider name, so the OIDC provider's subjects would be mistaken for the built-in provider's, even if it's not
+	// enabled any more
+	if c.OIDC.Client.ClientID != "" && slices.Contains(builtinProviders, c.OIDC.Name) {
+		errs = append(errs, fmt.Errorf("OIDC_NAME %w: %q is taken by a built-in provider", env.ErrInvalid, c.OIDC.Name))
+	}
+
+	switch {
+	case c.Signing.KeyringDir != "":
+		// the keyring is checked when it's loaded, as it can change while running
+	case c.Signing.Alg == "HS256":
+		if c.Signing.Secret == "" {
+			errs = append(errs, missing("JWT_SECRET", "when signing with HS256"))
+		}
+		if c.Signing.Salt == "" {
+			errs = append(errs, missing("JWT_SALT", "when signing with HS256"))
+		}
+	case c.Signing.Alg == "ES256":
+		if c.Signing.PrivateKeyPath == "" {
+			errs = append(errs, missing("JWT_PRIVATE_KEY_PATH", "when signing with ES256"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"wingbox.spencrc/internal/env"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := func() Config {
+		cfg := newTestConfig()
+		cfg.Discord = OAuthClientConfig{ClientID: "1234", ClientSecret: "shh"}
+		cfg.Signing = SigningConfig{Alg: "HS256", Secret: TEST_JWT_KEY, Salt: TEST_JWT_SALT}
+		return cfg
+	}
+
+	tests := []struct {
+		name    string
+		edit    func(cfg *Config)
+		wantErr []string
+	}{
+		{"valid", func(cfg *Config) {}, nil},
+		{"no providers", func(cfg *Config) { cfg.Discord = OAuthClientConfig{} }, []string{ErrNoProviders.Error()}},
+		{"client without secret", func(cfg *Config) { cfg.GitHub.ClientID = "abc" }, []string{"GITHUB_CLIENT_SECRET"}},
+		{"oidc without issuer", func(cfg *Config) { cfg.OIDC.Client = OAuthClientConfig{ClientID: "abc", ClientSecret: "shh"} }, []string{"OIDC_ISSUER"}},
+		{"oidc named after a built-in provider", func(cfg *Config) {
+			cfg.OIDC = OIDCConfig{Client: OAuthClientConfig{ClientID: "abc", ClientSecret: "shh"}, Name: "github", Issuer: "https://idp.example.com"}
+		}, []string{"OIDC_NAME"}},
+		{"hs256 without secret or salt", func(cfg *Config) { cfg.Signing = SigningConfig{Alg: "HS256"} }, []string{"JWT_SECRET", "JWT_SALT"}},
+		{"es256 without key", func(cfg *Config) { cfg.Signing = SigningConfig{Alg: "ES256"} }, []string{"JWT_PRIVATE_KEY_PATH"}},
+		{"keyring", func(cfg *Config) { cfg.Signing = SigningConfig{Alg: "HS256", KeyringDir: "/secrets/keyring"} }, nil},
+		// everything wrong at once should be reported at once
+		{"several", func(cfg *Config) {
+			cfg.Discord.ClientSecret = ""
+			cfg.Signing = SigningConfig{Alg: "ES256"}
+		}, []string{"DISCORD_CLIENT_SECRET", "JWT_PRIVATE_KEY_PATH"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.edit(&cfg)
+			err := cfg.Validate()
+
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("did not expect error, got %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error mentioning %v", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to mention %s, got %v", want, err)
+				}
+			}
+			if !errors.Is(err, ErrNoProviders) && !errors.Is(err, env.ErrMissing) && !errors.Is(err, env.ErrInvalid) {
+				t.Errorf("expected ErrMissing or ErrInvalid, got %v", err)
+			}
+		})
+	}
+}
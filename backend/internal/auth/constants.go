@@ -1,5 +1,19 @@
 package auth
 
+import (
+	"time"
+
+	"wingbox.spencrc/internal/middleware"
+)
+
 const DISCORD_BASE_URL = "https://discord.com"
-const ACCESS_MAX_AGE = 3 * 60
-const REFRESH_MAX_AGE = 30 * 24 * 3600
\ No newline at end of file
+const GITHUB_BASE_URL = "https://github.com"
+const GITHUB_API_URL = "https://api.github.com"
+// How long any one request to a provider gets, so a provider that hangs fails the login rather than leaving it waiting forever
+const PROVIDER_TIMEOUT = 10 * time.Second
+// shared with the api, so it can read access tokens itself. see middleware.RequireAuth
+const ACCESS_COOKIE_NAME = middleware.ACCESS_COOKIE_NAME
+const ACCESS_TOKEN_AUDIENCE = middleware.ACCESS_TOKEN_AUDIENCE
+const REFRESH_COOKIE_NAME = "__Http-DO_NOT_SHARE-refresh_token"
+const FLOW_MAX_AGE = 5 * 60
+const FLOW_COOKIE_NAME = "__Http-oauth_flow"
\ No newline at end of file
@@ -1,28 +1,46 @@
 package auth
 
 import (
-	"fmt"
-	"net/http"
-	"net/url"
+	"context"
 )
 
+type DiscordUserRes struct {
+	UserId string `json:"id"`
+}
+
+type discordProvider struct {
+	oauthClient
+}
+
+func (p *discordProvider) Name() string {
+	return "discord"
+}
+
 // Generates OAuth URL for Discord
-func generateDiscordUrl(clientId string, redirectURI string, state string) string {
-	params := url.Values{
-		"client_id":     {clientId},
-		"response_type": {"code"},
-		"redirect_uri":  {redirectURI},
-		"scope":         {"identify"},
-		"state":         {state},
-	}
+func (p *discordProvider) AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	return authCodeURL(DISCORD_BASE_URL+"/oauth2/authorize", p.oauthClient, "identify", state, codeChallenge), nil
+}
 
-	return fmt.Sprintf("%s?%s", DISCORD_BASE_URL + "/oauth2/authorize", params.Encode())
+func (p *discordProvider) Exchange(ctx context.Context, code string, verifier string) (TokenRes, error) {
+	return exchangeCode(ctx, DISCORD_BASE_URL+"/api/oauth2/token", p.oauthClient, code, verifier)
 }
 
-func (as *AuthService) Discord(w http.ResponseWriter, r *http.Request) {
-	state := generateState()
-	cookie := generateStateCookie(state)
-	discordUrl := generateDiscordUrl(as.clientId, as.redirectURI, state)
-	http.SetCookie(w, &cookie)
-	http.Redirect(w, r, discordUrl, http.StatusFound)
-}
\ No newline at end of file
+// Builds request to obtain current Discord user data, then fetches a response.
+// On failure, returns empty Identity and error.
+// On success, returns the user's Discord ID as an Identity and nil.
+func (p *discordProvider) Identity(ctx context.Context, tokens TokenRes, nonce string) (Identity, error) {
+	req, err := newBearerRequest(ctx, DISCORD_BASE_URL+"/api/users/@me", tokens)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var userData DiscordUserRes
+	if err = fetch(p.client, req, &userData); err != nil {
+		return Identity{}, err
+	}
+	if userData.UserId == "" {
+		return Identity{}, ErrMissingUserId
+	}
+
+	return Identity{Provider: p.Name(), Subject: userData.UserId}, nil
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	jwtcookie "github.com/stfsy/go-jwt-cookie"
+)
+
+// Everything the callback needs to know about the login (or link) it's finishing. Kept in a signed cookie between Login and Redirect,
+// so none of it can be swapped out by the user, or by anyone who intercepts the redirect back from the provider. The cookie's token also
+// carries when it was issued (iat), and is refused once it's older than FLOW_MAX_AGE.
+type oauthFlow struct {
+	// sent to the provider and brought back by it, so a callback can't be forged on the user's behalf (CSRF)
+	state string
+	// bound by OpenID Connect providers into their ID token. see Provider.Identity
+	nonce string
+	// PKCE (RFC 7636) code verifier. Only its hash is sent with the login, so a code intercepted on the way back is useless without it
+	verifier string
+	// whether Redirect should link the identity to the logged in user instead of logging in
+	link bool
+	// the page the user asked to be sent back to once they're logged in. Unchecked! see safeReturnTo
+	returnTo string
+}
+
+// Creates a flow with a fresh state, nonce and code verifier
+func newOAuthFlow(link bool, returnTo string) oauthFlow {
+	return oauthFlow{
+		state:    generateState(),
+		nonce:    generateState(),
+		verifier: generateVerifier(),
+		link:     link,
+		returnTo: returnTo,
+	}
+}
+
+// Generates a PKCE code verifier: 32 random bytes, base64url encoded to the 43 characters RFC 7636 asks for
+func generateVerifier() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// The S256 PKCE code challenge sent to the provider for the flow's verifier
+func (f oauthFlow) codeChallenge() string {
+	sum := sha256.Sum256([]byte(f.verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func newFlowManager(keys signingKeys) (*jwtcookie.CookieManager, error) {
+	return jwtcookie.NewCookieManager(append(keys.cookieOptions(),
+		jwtcookie.WithHTTPOnly(true),
+		jwtcookie.WithSecure(true),
+		jwtcookie.WithMaxAge(FLOW_MAX_AGE),
+		jwtcookie.WithIssuer("auth"),
+		// so an access or refresh token can never pass for a flow, or the other way around
+		jwtcookie.WithAudience("oauth-flow"),
+		jwtcookie.WithSameSite(http.SameSiteLaxMode), // needs to be lax so when user arrives back on website from the provider, the cookie still persists
+		jwtcookie.WithCookieName(FLOW_COOKIE_NAME),
+	)...)
+}
+
+// Signs the flow and sets it as a cookie on the response
+func (as *AuthService) setFlowCookie(w http.ResponseWriter, r *http.Request, flow oauthFlow) error {
+	if err := as.managers().keys.checkActive(time.Now()); err != nil {
+		return err
+	}
+
+	link := "0"
+	if flow.link {
+		link = "1"
+	}
+	return as.flowMgr().SetJWTCookie(w, r, map[string]string{
+		"state":    flow.state,
+		"nonce":    flow.nonce,
+		"verifier": flow.verifier,
+		"link":     link,
+		// claims can only hold URL safe characters, which paths aren't
+		"return_to": base64.RawURLEncoding.EncodeToString([]byte(flow.returnTo)),
+	})
+}
+
+// Gets the flow from its cookie, checks it was signed by us and hasn't expired, then checks the state the provider brought back matches it.
+// On failure, returns empty oauthFlow, empty string and error.
+// On success, returns the flow, the code to obtain the provider's tokens with, and nil.
+func (as *AuthService) redeemFlow(r *http.Request) (oauthFlow, string, error) {
+	claims, err := as.flowMgr().GetClaimsOfValid(r)
+	if err != nil {
+		return oauthFlow{}, "", err
+	}
+
+	flow := oauthFlow{}
+	flow.state, _ = claims["state"].(string)
+	flow.nonce, _ = claims["nonce"].(string)
+	flow.verifier, _ = claims["verifier"].(string)
+	flow.link = claims["link"] == "1"
+	flow.returnTo = "/"
+	if encoded, ok := claims["return_to"].(string); ok {
+		if returnTo, err := base64.RawURLEncoding.DecodeString(encoded); err == nil && len(returnTo) > 0 {
+			flow.returnTo = string(returnTo)
+		}
+	}
+
+	state := r.URL.Query().Get("state")
+	if flow.state == "" || state != flow.state {
+		return oauthFlow{}, "", ErrInvalidState
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		return oauthFlow{}, "", ErrMissingCode
+	}
+
+	return flow, code, nil
+}
+
+// Expires the flow cookie on the client, once the login it was for is finished
+func clearFlowCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     FLOW_COOKIE_NAME,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
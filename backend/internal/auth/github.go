@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+type GithubUserRes struct {
+	// unlike Discord, GitHub sends user IDs as numbers
+	UserId int64 `json:"id"`
+}
+
+var ErrMissingUserId error = errors.New("provider did not respond with a user ID")
+
+type githubProvider struct {
+	oauthClient
+}
+
+func (p *githubProvider) Name() string {
+	return "github"
+}
+
+// Generates OAuth URL for GitHub. No scope is needed, as public profile info (which includes the user's ID) is always readable.
+func (p *githubProvider) AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	return authCodeURL(GITHUB_BASE_URL+"/login/oauth/authorize", p.oauthClient, "", state, codeChallenge), nil
+}
+
+func (p *githubProvider) Exchange(ctx context.Context, code string, verifier string) (TokenRes, error) {
+	return exchangeCode(ctx, GITHUB_BASE_URL+"/login/oauth/access_token", p.oauthClient, code, verifier)
+}
+
+// Builds request to obtain current GitHub user data, then fetches a response.
+// On failure, returns empty Identity and error.
+// On success, returns the user's GitHub ID as an Identity and nil.
+func (p *githubProvider) Identity(ctx context.Context, tokens TokenRes, nonce string) (Identity, error) {
+	req, err := newBearerRequest(ctx, GITHUB_API_URL+"/user", tokens)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var userData GithubUserRes
+	if err = fetch(p.client, req, &userData); err != nil {
+		return Identity{}, err
+	}
+	if userData.UserId == 0 {
+		return Identity{}, ErrMissingUserId
+	}
+
+	return Identity{Provider: p.Name(), Subject: strconv.FormatInt(userData.UserId, 10)}, nil
+}
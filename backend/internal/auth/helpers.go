@@ -1,30 +1,40 @@
 package auth
 
 import (
-	"math/rand"
-	"net/http"
+	"crypto/rand"
+	"net/url"
+	"path"
+	"strings"
 )
 
-// Generates state code for PKCE for Discord
+// Generates the state code a login is tied to, so the provider's callback can be checked as belonging to it.
+// Must be unguessable, otherwise anyone could forge a callback for it, so it's 128 bits from crypto/rand.
 func generateState() string {
-	letters := []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+	return rand.Text()
+}
 
-	b := make([]byte, 20)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+// Checks the path the user asked to be sent back to after logging in is one of ours, so the login can't be used to bounce them off to
+// some other site (an open redirect). Only absolute paths on this site under one of the allowed prefixes pass.
+// Returns the path to send the user back to, which is "/" if the one asked for isn't allowed.
+func safeReturnTo(returnTo string, allowedPrefixes []string) string {
+	// "//evil.com" and "/\evil.com" are read by browsers as links to another host, so only a single forward slash will do
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.ContainsAny(returnTo, "\\\r\n\t") {
+		return "/"
 	}
 
-	return string(b)
-}
+	u, err := url.Parse(returnTo)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return "/"
+	}
 
-func generateStateCookie(state string) http.Cookie {
-	return http.Cookie{
-		Name:     "oauth_state",
-		Value:    state,
-		Path:     "/",
-		MaxAge:   300, // 5 minutes
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteLaxMode, // needs to be lax so when user arrives back on website from discord, the cookie still persists
+	// clean so "/allowed/../elsewhere" can't sneak past the prefix check
+	cleaned := path.Clean(u.Path)
+	for _, prefix := range allowedPrefixes {
+		prefix = strings.TrimSuffix(strings.TrimSpace(prefix), "/")
+		if prefix == "" || cleaned == prefix || strings.HasPrefix(cleaned, prefix+"/") {
+			return returnTo
+		}
 	}
-}
\ No newline at end of file
+
+	return "/"
+}
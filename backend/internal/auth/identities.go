@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"wingbox.spencrc/internal/server"
+)
+
+var ErrIdentityLinkedElsewhere error = errors.New("this identity is already linked to another user")
+var ErrLastIdentity error = errors.New("cannot unlink the only identity left to log in with")
+var ErrIdentityNotFound error = errors.New("no such identity is linked to this user")
+
+// Finds the user the identity is linked to, or, if it isn't linked to anyone yet, creates a new user with it as their first identity.
+// Looks up before inserting, as most logins are by existing users, and inserting would write lock the database when it need not.
+// Returns app's user ID.
+func ensureUser(ctx context.Context, db *sql.DB, identity Identity, userID *string) error {
+	const selectQuery = "SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?"
+	err := db.QueryRowContext(ctx, selectQuery, identity.Provider, identity.Subject).Scan(userID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = tx.QueryRowContext(ctx, "INSERT INTO users DEFAULT VALUES RETURNING id").Scan(userID); err != nil {
+		return err
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO user_identities (user_id, provider, subject)
+		VALUES (?, ?, ?)
+		ON CONFLICT(provider, subject) DO NOTHING
+		RETURNING user_id;
+	`, *userID, identity.Provider, identity.Subject).Scan(userID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// someone else logged in with the same identity in the meantime, so use the user they created instead of ours
+		tx.Rollback()
+		return db.QueryRowContext(ctx, selectQuery, identity.Provider, identity.Subject).Scan(userID)
+	case err != nil:
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Links the identity to the user, so they can log in with it too. Linking an identity the user already has does nothing.
+// Returns ErrIdentityLinkedElsewhere if it belongs to a different user, since taking it from them would lock them out.
+func linkIdentity(ctx context.Context, db *sql.DB, identity Identity, userID string) error {
+	var owner string
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO user_identities (user_id, provider, subject)
+		VALUES (?, ?, ?)
+		ON CONFLICT(provider, subject) DO NOTHING
+		RETURNING user_id;
+	`, userID, identity.Provider, identity.Subject).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = db.QueryRowContext(ctx, "SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?", identity.Provider, identity.Subject).Scan(&owner)
+	}
+	if err != nil {
+		return err
+	}
+
+	if owner != userID {
+		return ErrIdentityLinkedElsewhere
+	}
+	return nil
+}
+
+// Works out who the user is when they come back from linking. Access tokens are short lived, and the user may have taken longer than that
+// at the provider, so falls back to the refresh token as long as it's still redeemable.
+// On failure, returns empty string and ErrNotLoggedIn, or the database's error.
+// On success, returns the user's ID and nil.
+func (as *AuthService) sessionUser(r *http.Request) (string, error) {
+	sub, _, err := as.authenticate(r)
+	if !errors.Is(err, ErrNotLoggedIn) {
+		return sub, err
+	}
+
+	claims, err := as.refreshMgr().GetClaimsOfValid(r)
+	if err != nil {
+		return "", ErrNotLoggedIn
+	}
+	sub, jti, ok := subAndJti(claims)
+	if !ok {
+		return "", ErrNotLoggedIn
+	}
+
+	var exists int
+	err = as.server.Db.QueryRowContext(r.Context(),
+		"SELECT 1 FROM refresh_tokens WHERE jti = ? AND sub = ? AND consumed = 0 AND expires_at > ?", jti, sub, time.Now().Unix(),
+	).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotLoggedIn
+	}
+	if err != nil {
+		return "", err
+	}
+	return sub, nil
+}
+
+// Responds with the identities linked to the logged in user as JSON, e.g. [{"provider":"discord","subject":"1234"}]
+func (as *AuthService) Identities(w http.ResponseWriter, r *http.Request) error {
+	sub, _, err := as.authenticate(r)
+	if err != nil {
+		return err
+	}
+
+	rows, err := as.server.Db.QueryContext(r.Context(), "SELECT provider, subject FROM user_identities WHERE user_id = ? ORDER BY id", sub)
+	if err != nil {
+		return fmt.Errorf("failed to list identities: %w", err)
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		var identity Identity
+		if err = rows.Scan(&identity.Provider, &identity.Subject); err != nil {
+			return fmt.Errorf("failed to read identity: %w", err)
+		}
+		identities = append(identities, identity)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to list identities: %w", err)
+	}
+
+	return server.WriteJSON(w, http.StatusOK, identities)
+}
+
+// Unlinks one of the logged in user's identities, so it can no longer be used to log in as them. Refused with 409 if it's the last one
+// they have, as they'd have no way left to log in.
+func (as *AuthService) Unlink(w http.ResponseWriter, r *http.Request) error {
+	db := as.server.Db
+
+	sub, _, err := as.authenticate(r)
+	if err != nil {
+		return err
+	}
+
+	provider := r.PathValue("provider")
+	subject := r.PathValue("subject")
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// only deletes if another identity would be left, in the same statement, so two concurrent unlinks can't remove both of the last two
+	res, err := tx.ExecContext(r.Context(), `
+		DELETE FROM user_identities
+		WHERE user_id = ? AND provider = ? AND subject = ?
+		AND EXISTS (
+			SELECT 1 FROM user_identities WHERE user_id = ? AND NOT (provider = ? AND subject = ?)
+		);
+	`, sub, provider, subject, sub, provider, subject)
+	if err != nil {
+		return fmt.Errorf("failed to unlink identity: %w", err)
+	}
+
+	if deleted, _ := res.RowsAffected(); deleted == 0 {
+		// work out whether it was refused, or there was just nothing to delete
+		var exists int
+		err = tx.QueryRowContext(r.Context(), "SELECT 1 FROM user_identities WHERE user_id = ? AND provider = ? AND subject = ?", sub, provider, subject).Scan(&exists)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return problemIdentityMissing
+		case err != nil:
+			return fmt.Errorf("failed to look up identity: %w", err)
+		default:
+			return problemLastIdentity
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit unlink: %w", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"wingbox.spencrc/internal/server"
+)
+
+func TestLink(t *testing.T) {
+	t.Run("links to the logged in user", func(t *testing.T) {
+		fake := newFakeOIDC(t)
+		as := newTestAuthServiceWithDB(t)
+		as.providers = map[string]Provider{"oidc": fake.provider()}
+		mux := newFlowMux(as)
+
+		accessCookie := issueAccessCookie(t, as, map[string]string{"sub": "1", "jti": "link-session"})
+		rec := runFlow(t, fake, mux, "/link/oidc", accessCookie)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("expected link to succeed, got status %d: %s", rec.Code, rec.Body.String())
+		}
+		if n := countRows(t, as, "SELECT COUNT(*) FROM user_identities WHERE user_id = 1"); n != 2 {
+			t.Fatalf("expected user to have 2 identities, got %d", n)
+		}
+
+		// logging in with the linked identity should now log in as the same user, rather than creating a new one
+		rec = runFlow(t, fake, mux, "/login/oidc")
+		if rec.Code != http.StatusFound {
+			t.Fatalf("expected login to succeed, got status %d: %s", rec.Code, rec.Body.String())
+		}
+		req := httptest.NewRequest("GET", "/", nil)
+		for _, c := range rec.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		claims, err := as.accessMgr().GetClaimsOfValid(req)
+		if err != nil {
+			t.Fatalf("expected a valid access token, got %v", err)
+		}
+		if claims["sub"] != "1" {
+			t.Errorf("expected to be logged in as user 1, got %v", claims["sub"])
+		}
+		if n := countRows(t, as, "SELECT COUNT(*) FROM users"); n != 1 {
+			t.Errorf("expected no new user to be created, got %d users", n)
+		}
+	})
+
+	t.Run("identity belongs to another user", func(t *testing.T) {
+		fake := newFakeOIDC(t)
+		as := newTestAuthServiceWithDB(t)
+		as.providers = map[string]Provider{"oidc": fake.provider()}
+		as.server.Db.Exec("INSERT INTO users (id) VALUES (2); INSERT INTO user_identities (user_id, provider, subject) VALUES (2, 'oidc', ?)", OIDC_SUBJECT)
+
+		accessCookie := issueAccessCookie(t, as, map[string]string{"sub": "1", "jti": "link-session"})
+		rec := runFlow(t, fake, newFlowMux(as), "/link/oidc", accessCookie)
+		if rec.Code != http.StatusConflict {
+			t.Errorf("expected status %d, got %d: %s", http.StatusConflict, rec.Code, rec.Body.String())
+		}
+		if n := countRows(t, as, "SELECT COUNT(*) FROM user_identities WHERE user_id = 2"); n != 1 {
+			t.Errorf("expected the other user to keep their identity")
+		}
+	})
+
+	t.Run("not logged in", func(t *testing.T) {
+		as := newTestAuthServiceWithDB(t)
+		as.providers = map[string]Provider{"oidc": newFakeOIDC(t).provider()}
+
+		rec := httptest.NewRecorder()
+		newFlowMux(as).ServeHTTP(rec, httptest.NewRequest("GET", "/link/oidc", nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	})
+}
+
+func TestSessionUserFallsBackToRefreshToken(t *testing.T) {
+	as := newTestAuthServiceWithDB(t)
+	live := issueRefreshCookie(t, as, "live-jti", "1", time.Now().Add(time.Hour))
+	consumed := issueRefreshCookie(t, as, "consumed-jti", "1", time.Now().Add(time.Hour))
+	as.server.Db.Exec("UPDATE refresh_tokens SET consumed = 1 WHERE jti = 'consumed-jti'")
+
+	req := httptest.NewRequest("GET", "/callback/oidc", nil)
+	req.AddCookie(live)
+	if sub, err := as.sessionUser(req); err != nil || sub != "1" {
+		t.Errorf("expected user 1 from a live refresh token, got %q, %v", sub, err)
+	}
+
+	req = httptest.NewRequest("GET", "/callback/oidc", nil)
+	req.AddCookie(consumed)
+	if _, err := as.sessionUser(req); !errors.Is(err, ErrNotLoggedIn) {
+		t.Errorf("expected a consumed refresh token to be refused, got %v", err)
+	}
+}
+
+func TestIdentities(t *testing.T) {
+	as := newTestAuthServiceWithDB(t)
+	as.server.Db.Exec("INSERT INTO user_identities (user_id, provider, subject) VALUES (1, 'github', '5678')")
+
+	req := httptest.NewRequest("GET", "/identities", nil)
+	req.AddCookie(issueAccessCookie(t, as, map[string]string{"sub": "1", "jti": "abc-123"}))
+	rec := httptest.NewRecorder()
+	server.HandlerFunc(as.Identities).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var identities []Identity
+	if err := json.NewDecoder(rec.Body).Decode(&identities); err != nil {
+		t.Fatalf("could not decode identities: %v", err)
+	}
+	expected := []Identity{{"discord", "1234"}, {"github", "5678"}}
+	if len(identities) != len(expected) || identities[0] != expected[0] || identities[1] != expected[1] {
+		t.Errorf("expected identities %v, got %v", expected, identities)
+	}
+}
+
+func TestUnlink(t *testing.T) {
+	var tests = []struct {
+		name           string
+		extraIdentity  bool
+		path           string
+		loggedIn       bool
+		expectedStatus int
+		expectedLeft   int
+	}{
+		{
+			name:           "one of several identities",
+			extraIdentity:  true,
+			path:           "/identities/discord/1234",
+			loggedIn:       true,
+			expectedStatus: http.StatusNoContent,
+			expectedLeft:   1,
+		},
+		{
+			name:           "last identity",
+			path:           "/identities/discord/1234",
+			loggedIn:       true,
+			expectedStatus: http.StatusConflict,
+			expectedLeft:   1,
+		},
+		{
+			name:           "identity not linked to user",
+			extraIdentity:  true,
+			path:           "/identities/github/9999",
+			loggedIn:       true,
+			expectedStatus: http.StatusNotFound,
+			expectedLeft:   2,
+		},
+		{
+			name:           "not logged in",
+			extraIdentity:  true,
+			path:           "/identities/discord/1234",
+			expectedStatus: http.StatusUnauthorized,
+			expectedLeft:   2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			as := newTestAuthServiceWithDB(t)
+			if test.extraIdentity {
+				as.server.Db.Exec("INSERT INTO user_identities (user_id, provider, subject) VALUES (1, 'github', '5678')")
+			}
+
+			mux := http.NewServeMux()
+			mux.Handle("DELETE /identities/{provider}/{subject}", server.HandlerFunc(as.Unlink))
+			req := httptest.NewRequest("DELETE", test.path, nil)
+			if test.loggedIn {
+				req.AddCookie(issueAccessCookie(t, as, map[string]string{"sub": "1", "jti": "abc-123"}))
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != test.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", test.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if n := countRows(t, as, "SELECT COUNT(*) FROM user_identities WHERE user_id = 1"); n != test.expectedLeft {
+				t.Errorf("expected %d identities left, got %d", test.expectedLeft, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"wingbox.spencrc/internal/server"
+)
+
+// The manifest in a keyring directory, describing the keys in it. For example, partway through rotating from one ES256 key to another:
+//
+//	{
+//		"alg": "ES256",
+//		"active": "2026-10.pem",
+//		"keys": [
+//			{"file": "2026-10.pem"},
+//			{"file": "2026-04.pem", "not_after": "2026-11-20T00:00:00Z"}
+//		]
+//	}
+//
+// To rotate without logging anyone out: stage the new key by adding it to keys, wait for verifiers to pick it up, make it active, then
+// give the old key a not_after at least REFRESH_TOKEN_TTL later, so every token it signed has expired by the time it's dropped.
+// Verifiers pick up ES256 keys from the JWKS. HS256 keys can't be published, so the api has to be given the same keyring, with its own
+// JWT_KEYRING_DIR (see KeyringSecrets), which it reloads as often as auth does (every minute).
+// Once the active key is past its not_after, auth refuses to sign anything with it, and fails its readiness check, until another key is made
+// active. Give the active key a not_after only as a backstop, in case a rotation is forgotten.
+const KEYRING_MANIFEST = "keyring.json"
+
+type keyringManifest struct {
+	// HS256 (files hold the secret) or ES256 (files hold a PEM private key, see loadECDSAKey)
+	Alg string `json:"alg"`
+	// file of the key new tokens are signed with
+	Active string         `json:"active"`
+	Keys   []keyringEntry `json:"keys"`
+}
+
+type keyringEntry struct {
+	// file name in the keyring directory
+	File string `json:"file"`
+	// once past, the key is dropped and tokens it signed are no longer accepted. Optional
+	NotAfter *time.Time `json:"not_after,omitempty"`
+}
+
+var ErrNoActiveKey error = errors.New("keyring's active key is missing or past its not_after")
+var ErrNotHMACKeyring error = errors.New("keyring isn't HS256, so its keys should be verified with the JWKS instead")
+
+// Loads every key from the keyring in dir that isn't past its not_after at now. salt is only needed for HS256 keyrings.
+// Returns ErrNoActiveKey if there's no active key left to sign with.
+func loadKeyring(dir string, salt []byte, now time.Time) (signingKeys, error) {
+	keys, activeFound, err := readKeyring(dir, now)
+	if err != nil {
+		return signingKeys{}, err
+	}
+	if !activeFound {
+		return signingKeys{}, ErrNoActiveKey
+	}
+	if keys.method == jwt.SigningMethodHS256 {
+		if len(salt) == 0 {
+			return signingKeys{}, errors.New("HS256 keyrings need JWT_SALT to be set")
+		}
+		keys.salt = salt
+	}
+	return keys, nil
+}
+
+// The secrets the HS256 keyring in dir accepts at now, for services that only verify tokens, so they accept a key as soon as it's staged.
+// Whether there's an active key doesn't matter to them. Returns ErrNotHMACKeyring for ES256 keyrings.
+func KeyringSecrets(dir string, now time.Time) ([][]byte, error) {
+	keys, _, err := readKeyring(dir, now)
+	if err != nil {
+		return nil, err
+	}
+	if keys.method != jwt.SigningMethodHS256 {
+		return nil, ErrNotHMACKeyring
+	}
+
+	secrets := make([][]byte, 0, len(keys.accepted))
+	for _, key := range keys.accepted {
+		secrets = append(secrets, key.secret)
+	}
+	return secrets, nil
+}
+
+// Loads the manifest and every key in it that isn't past its not_after at now, leaving the salt for the caller. Also returns whether the
+// active key was among them.
+func readKeyring(dir string, now time.Time) (signingKeys, bool, error) {
+	data, err := os.ReadFile(filepath.Join(dir, KEYRING_MANIFEST))
+	if err != nil {
+		return signingKeys{}, false, err
+	}
+	var manifest keyringManifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return signingKeys{}, false, fmt.Errorf("invalid %s: %w", KEYRING_MANIFEST, err)
+	}
+
+	keys := signingKeys{}
+	switch manifest.Alg {
+	case "HS256":
+		keys.method = jwt.SigningMethodHS256
+	case "ES256":
+		keys.method = jwt.SigningMethodES256
+	default:
+		return signingKeys{}, false, fmt.Errorf("keyring alg must be HS256 or ES256, got %q", manifest.Alg)
+	}
+
+	activeFound := false
+	for _, entry := range manifest.Keys {
+		if entry.NotAfter != nil && !now.Before(*entry.NotAfter) {
+			continue
+		}
+		// keep files inside the keyring, so the manifest can't be used to read anything else
+		if entry.File == "" || filepath.Base(entry.File) != entry.File {
+			return signingKeys{}, false, fmt.Errorf("keyring file %q must be a plain file name", entry.File)
+		}
+
+		key, err := loadKeyringKey(filepath.Join(dir, entry.File), keys.method)
+		if err != nil {
+			return signingKeys{}, false, err
+		}
+		keys.accepted = append(keys.accepted, key)
+		if entry.File == manifest.Active {
+			keys.active = key
+			keys.activeNotAfter = entry.NotAfter
+			activeFound = true
+		}
+	}
+
+	return keys, activeFound, nil
+}
+
+// Calls reload every interval, until s shuts down, so a keyring can be rotated without restarting, and keys past their not_after are
+// dropped. reload should leave the current keys in place if it fails, e.g. as the keyring is caught halfway through being edited, as its
+// error is only logged.
+func WatchKeyring(s *server.Server, interval time.Duration, reload func() error) {
+	ctx, stopWatching := context.WithCancel(context.Background())
+	s.OnShutdown("keyring watcher", func(context.Context) error {
+		stopWatching()
+		return nil
+	})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := reload(); err != nil {
+				s.Logger.Error("could not reload JWT keyring, keeping current keys", "err", err)
+			}
+		}
+	}()
+}
+
+func loadKeyringKey(path string, method jwt.SigningMethod) (signingKey, error) {
+	if method == jwt.SigningMethodES256 {
+		private, err := loadECDSAKey(path)
+		return signingKey{private: private}, err
+	}
+
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return signingKey{}, err
+	}
+	// editors like to leave a trailing newline, which would otherwise become part of the secret
+	secret = bytes.TrimRight(secret, "\r\n")
+	if len(secret) == 0 {
+		return signingKey{}, fmt.Errorf("%s is empty", path)
+	}
+	return signingKey{secret: secret}, nil
+}
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wingbox.spencrc/internal/middleware"
+)
+
+// Writes a keyring manifest and the key files it names into a fresh directory
+func writeKeyring(t *testing.T, manifest string, files map[string][]byte) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, KEYRING_MANIFEST), []byte(manifest), 0600); err != nil {
+		t.Fatalf("could not write manifest: %v", err)
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatalf("could not write key: %v", err)
+		}
+	}
+	return dir
+}
+
+func newTestECDSAPEM(t *testing.T) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(newTestECDSAKey(t))
+	if err != nil {
+		t.Fatalf("could not encode key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestLoadKeyring(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	files := map[string][]byte{
+		"old.pem":     newTestECDSAPEM(t),
+		"current.pem": newTestECDSAPEM(t),
+		"staged.pem":  newTestECDSAPEM(t),
+		"retired.pem": newTestECDSAPEM(t),
+		"secret":      []byte("0123456789abcdef0123456789abcdef\n"),
+	}
+
+	var tests = []struct {
+		name             string
+		manifest         string
+		salt             string
+		expectedAccepted int
+		expectedError    error
+		expectError      bool
+	}{
+		{
+			name: "mid rotation",
+			manifest: `{"alg": "ES256", "active": "current.pem", "keys": [
+				{"file": "staged.pem"},
+				{"file": "current.pem"},
+				{"file": "old.pem", "not_after": "2026-11-01T00:00:00Z"},
+				{"file": "retired.pem", "not_after": "2026-09-01T00:00:00Z"}
+			]}`,
+			expectedAccepted: 3,
+		},
+		{
+			name:             "HS256",
+			manifest:         `{"alg": "HS256", "active": "secret", "keys": [{"file": "secret"}]}`,
+			salt:             "salt",
+			expectedAccepted: 1,
+		},
+		{
+			name:        "HS256 without salt",
+			manifest:    `{"alg": "HS256", "active": "secret", "keys": [{"file": "secret"}]}`,
+			expectError: true,
+		},
+		{
+			name:          "active key retired",
+			manifest:      `{"alg": "ES256", "active": "retired.pem", "keys": [{"file": "retired.pem", "not_after": "2026-09-01T00:00:00Z"}]}`,
+			expectedError: ErrNoActiveKey,
+		},
+		{
+			name:          "active key not listed",
+			manifest:      `{"alg": "ES256", "active": "staged.pem", "keys": [{"file": "current.pem"}]}`,
+			expectedError: ErrNoActiveKey,
+		},
+		{
+			name:        "file outside keyring",
+			manifest:    `{"alg": "ES256", "active": "current.pem", "keys": [{"file": "current.pem"}, {"file": "../auth.env"}]}`,
+			expectError: true,
+		},
+		{
+			name:        "unsupported alg",
+			manifest:    `{"alg": "none", "active": "current.pem", "keys": [{"file": "current.pem"}]}`,
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeKeyring(t, test.manifest, files)
+			keys, err := loadKeyring(dir, []byte(test.salt), now)
+
+			if test.expectedError != nil || test.expectError {
+				if err == nil || (test.expectedError != nil && !errors.Is(err, test.expectedError)) {
+					t.Errorf("expected error %v, got %v", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("did not expect error, got %v", err)
+			}
+			if len(keys.accepted) != test.expectedAccepted {
+				t.Errorf("expected %d accepted keys, got %d", test.expectedAccepted, len(keys.accepted))
+			}
+			if keys.method.Alg() == "HS256" && string(keys.active.secret) != "0123456789abcdef0123456789abcdef" {
+				t.Errorf("expected trailing newline to be trimmed from secret, got %q", keys.active.secret)
+			}
+		})
+	}
+}
+
+// Issues a refresh token cookie without recording it in the database, as only its signature matters here
+func signRefreshCookie(t *testing.T, as *AuthService) *http.Cookie {
+	rec := httptest.NewRecorder()
+	if err := as.refreshMgr().SetJWTCookie(rec, httptest.NewRequest("POST", "/", nil), map[string]string{"sub": "1", "jti": "abc-123"}); err != nil {
+		t.Fatalf("could not set refresh cookie: %v", err)
+	}
+	return rec.Result().Cookies()[0]
+}
+
+func acceptsRefreshCookie(as *AuthService, cookie *http.Cookie) bool {
+	req := httptest.NewRequest("POST", "/refresh", nil)
+	req.AddCookie(cookie)
+	_, err := as.refreshMgr().GetClaimsOfValid(req)
+	return err == nil
+}
+
+// Walks through a whole rotation: sessions from before the switch keep working until the old key is retired
+func TestKeyringRotation(t *testing.T) {
+	files := map[string][]byte{"a.pem": newTestECDSAPEM(t), "b.pem": newTestECDSAPEM(t)}
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	load := func(manifest string, now time.Time) *AuthService {
+		keys, err := loadKeyring(writeKeyring(t, manifest, files), nil, now)
+		if err != nil {
+			t.Fatalf("could not load keyring: %v", err)
+		}
+		return newTestAuthServiceWithKeys(t, keys)
+	}
+
+	before := load(`{"alg": "ES256", "active": "a.pem", "keys": [{"file": "a.pem"}]}`, start)
+	oldSession := signRefreshCookie(t, before)
+
+	// b is staged: published, but a still signs
+	staged := load(`{"alg": "ES256", "active": "a.pem", "keys": [{"file": "a.pem"}, {"file": "b.pem"}]}`, start)
+	if set, _ := staged.managers().keys.jwks(); len(set.Keys) != 2 {
+		t.Errorf("expected staged key to be published, got %d keys", len(set.Keys))
+	}
+
+	// b is switched to, and a is set to retire once every token it signed has expired
+	const switched = `{"alg": "ES256", "active": "b.pem", "keys": [{"file": "b.pem"}, {"file": "a.pem", "not_after": "2026-11-01T00:00:00Z"}]}`
+	after := load(switched, start)
+	if !acceptsRefreshCookie(after, oldSession) {
+		t.Errorf("expected session from before the switch to still be accepted")
+	}
+	newSession := signRefreshCookie(t, after)
+	if acceptsRefreshCookie(before, newSession) {
+		t.Errorf("expected session after the switch to be signed by the new key")
+	}
+
+	retired := load(switched, start.AddDate(0, 2, 0))
+	if acceptsRefreshCookie(retired, oldSession) {
+		t.Errorf("expected session signed by the retired key to be refused")
+	}
+	if !acceptsRefreshCookie(retired, newSession) {
+		t.Errorf("expected session signed by the active key to still be accepted")
+	}
+}
+
+// An active key past its not_after stops signing, even though the watcher keeps it around when the keyring no longer loads
+func TestKeyringActiveKeyExpires(t *testing.T) {
+	notAfter := time.Now().Add(-time.Minute)
+	dir := writeKeyring(t, `{"alg": "ES256", "active": "a.pem", "keys": [{"file": "a.pem", "not_after": "`+notAfter.Format(time.RFC3339)+`"}]}`,
+		map[string][]byte{"a.pem": newTestECDSAPEM(t)})
+
+	// as loaded before it expired
+	keys, err := loadKeyring(dir, nil, notAfter.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("could not load keyring: %v", err)
+	}
+	as := newTestAuthServiceWithKeys(t, keys)
+
+	if err = as.setTokenCookies(httptest.NewRecorder(), httptest.NewRequest("POST", "/refresh", nil), newTokenPair("1")); !errors.Is(err, ErrNoActiveKey) {
+		t.Errorf("expected expired active key to refuse to sign, got %v", err)
+	}
+	if _, err = loadKeyring(dir, nil, time.Now()); !errors.Is(err, ErrNoActiveKey) {
+		t.Errorf("expected reloading to fail with ErrNoActiveKey, got %v", err)
+	}
+}
+
+// With an HS256 keyring, the api verifies with the keyring's secrets, so it has to accept tokens signed with whichever key is active
+func TestKeyringSecrets(t *testing.T) {
+	files := map[string][]byte{"a": []byte("0123456789abcdef0123456789abcdef"), "b": []byte("fedcba9876543210fedcba9876543210\n")}
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	dir := writeKeyring(t, `{"alg": "HS256", "active": "b", "keys": [{"file": "b"}, {"file": "a", "not_after": "2026-11-01T00:00:00Z"}]}`, files)
+
+	keys, err := loadKeyring(dir, []byte(TEST_JWT_SALT), now)
+	if err != nil {
+		t.Fatalf("could not load keyring: %v", err)
+	}
+	as := newTestAuthServiceWithKeys(t, keys)
+	cookie := issueAccessCookie(t, as, map[string]string{"sub": "1", "jti": "abc-123"})
+
+	secrets, err := KeyringSecrets(dir, now)
+	if err != nil {
+		t.Fatalf("did not expect error, got %v", err)
+	}
+	if len(secrets) != 2 {
+		t.Fatalf("expected both keys' secrets, got %d", len(secrets))
+	}
+	if _, err = middleware.NewHMACVerifier(secrets...).Verify(context.Background(), cookie.Value); err != nil {
+		t.Errorf("expected token signed with the active key to verify, got %v", err)
+	}
+
+	// past a's not_after, only b is left
+	if secrets, _ = KeyringSecrets(dir, time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)); len(secrets) != 1 {
+		t.Errorf("expected retired key to be dropped, got %d secrets", len(secrets))
+	}
+
+	es256 := writeKeyring(t, `{"alg": "ES256", "active": "a.pem", "keys": [{"file": "a.pem"}]}`, map[string][]byte{"a.pem": newTestECDSAPEM(t)})
+	if _, err = KeyringSecrets(es256, now); !errors.Is(err, ErrNotHMACKeyring) {
+		t.Errorf("expected ErrNotHMACKeyring, got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Looks up the provider named in the route's {provider} wildcard. Returns problemUnknownProvider if there's no such provider configured.
+func (as *AuthService) providerFromPath(r *http.Request) (Provider, error) {
+	provider, ok := as.providers[r.PathValue("provider")]
+	if !ok {
+		return nil, problemUnknownProvider
+	}
+	return provider, nil
+}
+
+// Sends the user off to the provider with a fresh flow, which they'll bring back to Redirect
+func (as *AuthService) startFlow(w http.ResponseWriter, r *http.Request, provider Provider, link bool) error {
+	flow := newOAuthFlow(link, r.URL.Query().Get("return_to"))
+	authURL, err := provider.AuthCodeURL(r.Context(), flow.state, flow.nonce, flow.codeChallenge())
+	if err != nil {
+		return fmt.Errorf("failed to build login URL for provider %s: %w: %w", provider.Name(), problemProviderFailed, err)
+	}
+
+	if err = as.setFlowCookie(w, r, flow); err != nil {
+		return fmt.Errorf("failed to set oauth flow cookie: %w", err)
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+	return nil
+}
+
+// Starts logging in. Once done, the user is sent back to the page in the return_to query parameter, e.g. /login/discord?return_to=/settings
+func (as *AuthService) Login(w http.ResponseWriter, r *http.Request) error {
+	provider, err := as.providerFromPath(r)
+	if err != nil {
+		return err
+	}
+
+	return as.startFlow(w, r, provider, false)
+}
+
+// Starts linking another provider's identity to the logged in user, so they can log in with it too. Works like Login, except Redirect
+// attaches the identity to the current user instead of logging in as whoever it belongs to.
+func (as *AuthService) Link(w http.ResponseWriter, r *http.Request) error {
+	provider, err := as.providerFromPath(r)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = as.authenticate(r)
+	if err != nil {
+		return err
+	}
+
+	return as.startFlow(w, r, provider, true)
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"wingbox.spencrc/internal/server"
+)
+
+func TestLogin(t *testing.T) {
+	as := newTestAuthService(t)
+	as.providers = map[string]Provider{
+		"discord": &discordProvider{oauthClient{clientId: "id", redirectURI: "https://example.com/auth/callback/discord"}},
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/login/{provider}", server.HandlerFunc(as.Login))
+
+	t.Run("known provider", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/login/discord", nil))
+
+		if rec.Code != http.StatusFound {
+			t.Fatalf("expected status %d, got %d", http.StatusFound, rec.Code)
+		}
+
+		location, err := url.Parse(rec.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("could not parse redirect location: %v", err)
+		}
+		if location.Host != "discord.com" {
+			t.Errorf("expected to be redirected to discord.com, got %s", location.Host)
+		}
+
+		req := httptest.NewRequest("GET", "/callback/discord?code=code&"+location.RawQuery, nil)
+		for _, c := range rec.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		flow, _, err := as.redeemFlow(req)
+		if err != nil {
+			t.Fatalf("expected flow cookie to match the state sent to the provider, got %v", err)
+		}
+		if location.Query().Get("code_challenge_method") != "S256" || location.Query().Get("code_challenge") != flow.codeChallenge() {
+			t.Errorf("expected the S256 challenge for the flow's verifier to be sent, got %s", location.RawQuery)
+		}
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/login/myspace", nil))
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("expected status %d, got %d", http.StatusNotFound, rec.Code)
+		}
+	})
+}
+
+func TestSafeReturnTo(t *testing.T) {
+	var tests = []struct {
+		name     string
+		returnTo string
+		allowed  []string
+		expected string
+	}{
+		{name: "path on this site", returnTo: "/settings?tab=account", allowed: []string{"/"}, expected: "/settings?tab=account"},
+		{name: "nothing asked for", returnTo: "", allowed: []string{"/"}, expected: "/"},
+		{name: "absolute URL", returnTo: "https://evil.example.com/", allowed: []string{"/"}, expected: "/"},
+		{name: "protocol relative URL", returnTo: "//evil.example.com/", allowed: []string{"/"}, expected: "/"},
+		{name: "backslash", returnTo: "/\\evil.example.com", allowed: []string{"/"}, expected: "/"},
+		{name: "scheme without slashes", returnTo: "javascript:alert(1)", allowed: []string{"/"}, expected: "/"},
+		{name: "relative path", returnTo: "settings", allowed: []string{"/"}, expected: "/"},
+		{name: "header injection", returnTo: "/\r\nSet-Cookie: a=b", allowed: []string{"/"}, expected: "/"},
+		{name: "allowed prefix", returnTo: "/app/boxes/1", allowed: []string{"/app", "/settings"}, expected: "/app/boxes/1"},
+		{name: "prefix is not a path segment", returnTo: "/application", allowed: []string{"/app"}, expected: "/"},
+		{name: "dot segments out of prefix", returnTo: "/app/../admin", allowed: []string{"/app/"}, expected: "/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := safeReturnTo(test.returnTo, test.allowed); got != test.expected {
+				t.Errorf("expected %q to become %q, got %q", test.returnTo, test.expected, got)
+			}
+		})
+	}
+}
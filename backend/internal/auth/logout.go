@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"wingbox.spencrc/internal/problem"
+)
+
+// Blocklists the access token's jti until it expires, since access tokens can't otherwise be taken back once issued.
+// Also clears out blocklist entries for tokens that have expired on their own, as they're no longer needed.
+func revokeAccessToken(ctx context.Context, tx *sql.Tx, claims jwt.MapClaims, ttl time.Duration) error {
+	_, jti, ok := subAndJti(claims)
+	if !ok {
+		return nil
+	}
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		// shouldn't happen, since the cookie manager always sets exp. assume it lives as long as any access token could
+		exp = jwt.NewNumericDate(time.Now().Add(ttl))
+	}
+
+	now := time.Now().Unix()
+	if _, err = tx.ExecContext(ctx, "DELETE FROM revoked_access_tokens WHERE expires_at <= ?", now); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO revoked_access_tokens (jti, expires_at)
+		VALUES (?, ?)
+		ON CONFLICT(jti) DO NOTHING;
+	`, jti, exp.Unix())
+	return err
+}
+
+// Checks if the access token's jti was blocklisted by revokeAccessToken
+func isAccessTokenRevoked(ctx context.Context, db *sql.DB, jti string) (bool, error) {
+	var exists int
+	err := db.QueryRowContext(ctx, "SELECT 1 FROM revoked_access_tokens WHERE jti = ? AND expires_at > ?", jti, time.Now().Unix()).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Expires the access and refresh cookies on the client. Attributes match what the cookie managers set, otherwise browsers won't replace them.
+func clearTokenCookies(w http.ResponseWriter) {
+	for _, name := range []string{ACCESS_COOKIE_NAME, REFRESH_COOKIE_NAME} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+// Ends the current session: deletes its refresh token, blocklists its access token, and clears both cookies.
+// Always succeeds from the client's point of view, even if the tokens were already invalid, so a stale cookie can't get a user stuck logged in.
+func (as *AuthService) Logout(w http.ResponseWriter, r *http.Request) error {
+	db := as.server.Db
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if refreshClaims, err := as.refreshMgr().GetClaimsOfValid(r); err == nil {
+		if sub, jti, ok := subAndJti(refreshClaims); ok {
+			if _, err = tx.ExecContext(r.Context(), "DELETE FROM refresh_tokens WHERE jti = ? AND sub = ?", jti, sub); err != nil {
+				return fmt.Errorf("failed to delete refresh token: %w", err)
+			}
+		}
+	}
+
+	if accessClaims, err := as.accessMgr().GetClaimsOfValid(r); err == nil {
+		if err = revokeAccessToken(r.Context(), tx, accessClaims, as.cfg.AccessTokenTTL); err != nil {
+			return fmt.Errorf("failed to revoke access token: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit logout: %w", err)
+	}
+
+	clearTokenCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Ends every session the user has by deleting all of their refresh tokens, then logs out the current session like Logout.
+// Access tokens held by other sessions can't be blocklisted since we never see their jti, but they'll stop working within ACCESS_TOKEN_TTL.
+func (as *AuthService) LogoutAll(w http.ResponseWriter, r *http.Request) error {
+	db := as.server.Db
+
+	// prefer the access token to work out who the user is, but fall back to the refresh token in case it's expired
+	accessClaims, accessErr := as.accessMgr().GetClaimsOfValid(r)
+	claims := accessClaims
+	if accessErr != nil {
+		var err error
+		if claims, err = as.refreshMgr().GetClaimsOfValid(r); err != nil {
+			return problem.NotLoggedIn
+		}
+	}
+
+	sub, _, ok := subAndJti(claims)
+	if !ok {
+		return problem.NotLoggedIn
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(r.Context(), "DELETE FROM refresh_tokens WHERE sub = ?", sub); err != nil {
+		return fmt.Errorf("failed to delete refresh tokens: %w", err)
+	}
+
+	if accessErr == nil {
+		if err = revokeAccessToken(r.Context(), tx, accessClaims, as.cfg.AccessTokenTTL); err != nil {
+			return fmt.Errorf("failed to revoke access token: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit logout: %w", err)
+	}
+
+	clearTokenCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"wingbox.spencrc/internal/server"
+)
+
+func countRows(t *testing.T, as *AuthService, query string, args ...any) int {
+	var count int
+	if err := as.server.Db.QueryRow(query, args...).Scan(&count); err != nil {
+		t.Fatalf("could not count rows: %v", err)
+	}
+	return count
+}
+
+// Checks that both token cookies were expired on the response
+func expectClearedCookies(t *testing.T, rec *httptest.ResponseRecorder) {
+	cleared := map[string]bool{}
+	for _, c := range rec.Result().Cookies() {
+		if c.MaxAge < 0 {
+			cleared[c.Name] = true
+		}
+	}
+	if !cleared[ACCESS_COOKIE_NAME] || !cleared[REFRESH_COOKIE_NAME] {
+		t.Errorf("expected both token cookies to be cleared, got %v", rec.Result().Cookies())
+	}
+}
+
+func TestLogout(t *testing.T) {
+	as := newTestAuthServiceWithDB(t)
+	refreshCookie := issueRefreshCookie(t, as, "this-session", "1", time.Now().Add(time.Hour))
+	issueRefreshCookie(t, as, "other-session", "1", time.Now().Add(time.Hour))
+	accessCookie := issueAccessCookie(t, as, map[string]string{"jti": "access-jti", "sub": "1"})
+
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.AddCookie(refreshCookie)
+	req.AddCookie(accessCookie)
+	rec := httptest.NewRecorder()
+	server.HandlerFunc(as.Logout).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body.String())
+	}
+	expectClearedCookies(t, rec)
+
+	if n := countRows(t, as, "SELECT COUNT(*) FROM refresh_tokens WHERE jti = 'this-session'"); n != 0 {
+		t.Errorf("expected current refresh token to be deleted")
+	}
+	if n := countRows(t, as, "SELECT COUNT(*) FROM refresh_tokens WHERE jti = 'other-session'"); n != 1 {
+		t.Errorf("expected other sessions to be left alone")
+	}
+
+	// the access token should no longer get through nginx
+	req = httptest.NewRequest("GET", "/verify", nil)
+	req.AddCookie(accessCookie)
+	rec = httptest.NewRecorder()
+	server.HandlerFunc(as.Verify).ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected revoked access token to get status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestLogoutWithoutTokens(t *testing.T) {
+	as := newTestAuthServiceWithDB(t)
+
+	rec := httptest.NewRecorder()
+	server.HandlerFunc(as.Logout).ServeHTTP(rec, httptest.NewRequest("POST", "/logout", nil))
+
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	expectClearedCookies(t, rec)
+}
+
+func TestLogoutAll(t *testing.T) {
+	as := newTestAuthServiceWithDB(t)
+	refreshCookie := issueRefreshCookie(t, as, "this-session", "1", time.Now().Add(time.Hour))
+	issueRefreshCookie(t, as, "other-session", "1", time.Now().Add(time.Hour))
+
+	// only a refresh token, as if the access token had already expired
+	req := httptest.NewRequest("POST", "/logout/all", nil)
+	req.AddCookie(refreshCookie)
+	rec := httptest.NewRecorder()
+	server.HandlerFunc(as.LogoutAll).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body.String())
+	}
+	expectClearedCookies(t, rec)
+
+	if n := countRows(t, as, "SELECT COUNT(*) FROM refresh_tokens WHERE sub = '1'"); n != 0 {
+		t.Errorf("expected every refresh token for the user to be deleted, %d remain", n)
+	}
+}
+
+func TestLogoutAllRequiresLogin(t *testing.T) {
+	as := newTestAuthServiceWithDB(t)
+
+	rec := httptest.NewRecorder()
+	server.HandlerFunc(as.LogoutAll).ServeHTTP(rec, httptest.NewRequest("POST", "/logout/all", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Outcomes of logins, links and refreshes, for the result label. Anything that isn't the user's fault is just "error".
+const (
+	RESULT_SUCCESS          = "success"
+	RESULT_ERROR            = "error"
+	RESULT_INVALID_STATE    = "invalid_state"
+	RESULT_PROVIDER_FAILED  = "provider_failed"
+	RESULT_NOT_LOGGED_IN    = "not_logged_in"
+	RESULT_LINKED_ELSEWHERE = "linked_elsewhere"
+	RESULT_INVALID_TOKEN    = "invalid_token"
+	RESULT_REVOKED          = "revoked"
+	RESULT_REUSED           = "reused"
+	RESULT_JUST_USED        = "just_used"
+)
+
+// Counters for what the auth service does, on top of the HTTP metrics every server has
+type authMetrics struct {
+	logins    *prometheus.CounterVec
+	links     *prometheus.CounterVec
+	refreshes *prometheus.CounterVec
+}
+
+func newAuthMetrics(reg prometheus.Registerer) *authMetrics {
+	return &authMetrics{
+		logins: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "wingbox",
+			Subsystem: "auth",
+			Name:      "logins_total",
+			Help:      "Users coming back from logging in with a provider, by provider and result.",
+		}, []string{"provider", "result"}),
+		links: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "wingbox",
+			Subsystem: "auth",
+			Name:      "links_total",
+			Help:      "Users coming back from linking a provider's identity, by provider and result.",
+		}, []string{"provider", "result"}),
+		refreshes: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "wingbox",
+			Subsystem: "auth",
+			Name:      "refreshes_total",
+			Help:      "Refresh token redemptions, by result. reused means a stolen token was likely replayed, just_used that two requests raced to use the same one.",
+		}, []string{"result"}),
+	}
+}
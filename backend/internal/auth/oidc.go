@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+	"wingbox.spencrc/internal/jwks"
+)
+
+// The parts of an OpenID provider's /.well-known/openid-configuration we use
+type OIDCDiscoveryRes struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+var ErrMissingIDToken error = errors.New("provider did not respond with an ID token")
+var ErrInvalidNonce error = errors.New("ID token nonce does not match the one sent with the login")
+
+// Algorithms ID tokens may be signed with. Notably excludes "none" and the HMAC family, which would let anyone who knows our client secret forge one.
+var idTokenAlgs = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// A generic OpenID Connect provider, e.g. Keycloak, Authentik or Google. Its endpoints and signing keys are discovered from the issuer, and users are
+// identified by the subject of the ID token it signs, so no provider specific code is needed.
+type oidcProvider struct {
+	oauthClient
+	name   string
+	issuer string
+	scope  string
+
+	// discovered lazily on first use, so the auth service can still start while the provider is down
+	mu        sync.Mutex
+	discovery *OIDCDiscoveryRes
+	keys      *jwks.Cache
+}
+
+func newOIDCProvider(name string, issuer string, scope string, oc oauthClient) *oidcProvider {
+	return &oidcProvider{
+		oauthClient: oc,
+		name:        name,
+		issuer:      issuer,
+		scope:       scope,
+	}
+}
+
+// Fetches the provider's discovery document, or returns the one fetched earlier. p.mu isn't held while fetching, so one slow fetch doesn't
+// hold up every other login with the provider; logins racing to make the first fetch each make their own, and the first to finish wins.
+// On failure (unreachable, or it claims to be a different issuer), returns nil and error.
+func (p *oidcProvider) discover(ctx context.Context) (*OIDCDiscoveryRes, *jwks.Cache, error) {
+	p.mu.Lock()
+	discovery, keys := p.discovery, p.keys
+	p.mu.Unlock()
+	if discovery != nil {
+		return discovery, keys, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	discovery = &OIDCDiscoveryRes{}
+	if err = fetch(p.client, req, discovery); err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch discovery document: %w", err)
+	}
+	// per OpenID Connect Discovery 1.0 section 4.3, the issuer it reports must be exactly the one we asked (ID tokens' iss is checked against it too)
+	if discovery.Issuer != p.issuer {
+		return nil, nil, fmt.Errorf("discovery document is for issuer %q, expected %q", discovery.Issuer, p.issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JwksURI == "" {
+		return nil, nil, errors.New("discovery document is missing required endpoints")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery == nil {
+		p.discovery = discovery
+		p.keys = jwks.NewCache(discovery.JwksURI, p.client)
+	}
+	return p.discovery, p.keys, nil
+}
+
+func (p *oidcProvider) Name() string {
+	return p.name
+}
+
+func (p *oidcProvider) AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	discovery, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return authCodeURL(discovery.AuthorizationEndpoint, p.oauthClient, p.scope, state, codeChallenge) + "&" + url.Values{"nonce": {nonce}}.Encode(), nil
+}
+
+func (p *oidcProvider) Exchange(ctx context.Context, code string, verifier string) (TokenRes, error) {
+	discovery, _, err := p.discover(ctx)
+	if err != nil {
+		return TokenRes{}, err
+	}
+	return exchangeCode(ctx, discovery.TokenEndpoint, p.oauthClient, code, verifier)
+}
+
+// Verifies the ID token from the token response: its signature against the provider's published keys, that it was issued by the provider for us,
+// that it hasn't expired, and that it carries the nonce from this login (so an ID token from some other login can't be replayed).
+// On failure, returns empty Identity and error.
+// On success, returns the token's subject as an Identity and nil.
+func (p *oidcProvider) Identity(ctx context.Context, tokens TokenRes, nonce string) (Identity, error) {
+	if tokens.IDToken == "" {
+		return Identity{}, ErrMissingIDToken
+	}
+
+	_, keys, err := p.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(idTokenAlgs),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.clientId),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+
+	claims := jwt.MapClaims{}
+	if _, err = parser.ParseWithClaims(tokens.IDToken, claims, keys.Keyfunc(ctx)); err != nil {
+		return Identity{}, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	if tokenNonce, _ := claims["nonce"].(string); nonce == "" || tokenNonce != nonce {
+		return Identity{}, ErrInvalidNonce
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return Identity{}, ErrMissingUserId
+	}
+
+	return Identity{Provider: p.Name(), Subject: subject}, nil
+}
@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"wingbox.spencrc/internal/jwks"
+	"wingbox.spencrc/internal/server"
+)
+
+const OIDC_CLIENT_ID = "wingbox-client"
+const OIDC_CLIENT_SECRET = "wingbox-secret"
+
+// nginx strips the /auth prefix before requests reach the auth service, so the test routes see the bare path
+const OIDC_REDIRECT_URI = "https://wingbox.test/callback/oidc"
+const OIDC_SUBJECT = "oidc-user-1"
+
+// A tiny in-process OpenID provider: just enough discovery, authorize, token and JWKS endpoints to log in against
+type fakeOIDC struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+
+	mu sync.Mutex
+	// nonce and PKCE challenge sent to /authorize, keyed by the code it handed out
+	nonces     map[string]string
+	challenges map[string]string
+	// lets tests tamper with the ID token's claims before it's signed
+	mutateClaims func(claims jwt.MapClaims)
+	// lets tests sign the ID token with something other than the published key
+	signingKey any
+}
+
+func newFakeOIDC(t *testing.T) *fakeOIDC {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	f := &fakeOIDC{key: key, kid: "test-key", nonces: map[string]string{}, challenges: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", f.discovery)
+	mux.HandleFunc("GET /authorize", f.authorize)
+	mux.HandleFunc("POST /token", f.token)
+	mux.HandleFunc("GET /jwks", f.jwks)
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+
+	return f
+}
+
+func (f *fakeOIDC) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(OIDCDiscoveryRes{
+		Issuer:                f.server.URL,
+		AuthorizationEndpoint: f.server.URL + "/authorize",
+		TokenEndpoint:         f.server.URL + "/token",
+		JwksURI:               f.server.URL + "/jwks",
+	})
+}
+
+// Logs the user straight in and sends them back with a code
+func (f *fakeOIDC) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	code := "code-" + query.Get("state")
+
+	f.mu.Lock()
+	f.nonces[code] = query.Get("nonce")
+	if query.Get("code_challenge_method") == "S256" {
+		f.challenges[code] = query.Get("code_challenge")
+	}
+	f.mu.Unlock()
+
+	redirect := query.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+func (f *fakeOIDC) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if r.PostForm.Get("client_id") != OIDC_CLIENT_ID || r.PostForm.Get("client_secret") != OIDC_CLIENT_SECRET {
+		http.Error(w, "bad client credentials", http.StatusUnauthorized)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	code := r.PostForm.Get("code")
+	nonce, ok := f.nonces[code]
+	if !ok {
+		http.Error(w, "unknown code", http.StatusBadRequest)
+		return
+	}
+	// a code is only good to whoever holds the verifier for the challenge it was issued with
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if f.challenges[code] == "" || base64.RawURLEncoding.EncodeToString(sum[:]) != f.challenges[code] {
+		http.Error(w, "code verifier does not match challenge", http.StatusBadRequest)
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   f.server.URL,
+		"aud":   OIDC_CLIENT_ID,
+		"sub":   OIDC_SUBJECT,
+		"nonce": nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+	if f.mutateClaims != nil {
+		f.mutateClaims(claims)
+	}
+
+	var signingKey any = f.key
+	if f.signingKey != nil {
+		signingKey = f.signingKey
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = f.kid
+	idToken, err := token.SignedString(signingKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "oidc-access-token", "id_token": idToken})
+}
+
+func (f *fakeOIDC) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(jwks.Set{Keys: []jwks.Key{{
+		Kty: "RSA",
+		Kid: f.kid,
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+	}}})
+}
+
+func (f *fakeOIDC) provider() *oidcProvider {
+	return newOIDCProvider("oidc", f.server.URL, "openid", oauthClient{
+		clientId:     OIDC_CLIENT_ID,
+		clientSecret: OIDC_CLIENT_SECRET,
+		redirectURI:  OIDC_REDIRECT_URI,
+		client:       f.server.Client(),
+	})
+}
+
+// Goes through /authorize and /token for the given flow, then verifies the resulting ID token the way Redirect does
+func (f *fakeOIDC) login(t *testing.T, provider *oidcProvider, flow oauthFlow) (Identity, error) {
+	ctx := context.Background()
+	authURL, err := provider.AuthCodeURL(ctx, flow.state, flow.nonce, flow.codeChallenge())
+	if err != nil {
+		t.Fatalf("could not build login URL: %v", err)
+	}
+
+	client := f.server.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("could not reach authorize endpoint: %v", err)
+	}
+	res.Body.Close()
+
+	callback, _ := url.Parse(res.Header.Get("Location"))
+	tokens, err := provider.Exchange(ctx, callback.Query().Get("code"), flow.verifier)
+	if err != nil {
+		t.Fatalf("could not exchange code: %v", err)
+	}
+
+	return provider.Identity(ctx, tokens, flow.nonce)
+}
+
+func TestOIDCIdentity(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	var tests = []struct {
+		name          string
+		mutateClaims  func(claims jwt.MapClaims)
+		signingKey    any
+		expectedError error
+	}{
+		{
+			name: "valid ID token",
+		},
+		{
+			name:          "wrong nonce",
+			mutateClaims:  func(claims jwt.MapClaims) { claims["nonce"] = "someone-elses-nonce" },
+			expectedError: ErrInvalidNonce,
+		},
+		{
+			name:          "wrong audience",
+			mutateClaims:  func(claims jwt.MapClaims) { claims["aud"] = "some-other-client" },
+			expectedError: jwt.ErrTokenInvalidAudience,
+		},
+		{
+			name:          "wrong issuer",
+			mutateClaims:  func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" },
+			expectedError: jwt.ErrTokenInvalidIssuer,
+		},
+		{
+			name:          "expired",
+			mutateClaims:  func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+			expectedError: jwt.ErrTokenExpired,
+		},
+		{
+			name:          "missing expiry",
+			mutateClaims:  func(claims jwt.MapClaims) { delete(claims, "exp") },
+			expectedError: jwt.ErrTokenRequiredClaimMissing,
+		},
+		{
+			name:          "signed with an unpublished key",
+			signingKey:    otherKey,
+			expectedError: jwt.ErrTokenSignatureInvalid,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeOIDC(t)
+			fake.mutateClaims = test.mutateClaims
+			fake.signingKey = test.signingKey
+
+			identity, err := fake.login(t, fake.provider(), newOAuthFlow(false, "/"))
+			if test.expectedError == nil {
+				if err != nil {
+					t.Fatalf("did not expect error, got %v", err)
+				}
+				if identity.Subject != OIDC_SUBJECT || identity.Provider != "oidc" {
+					t.Errorf("expected identity oidc/%s, got %s/%s", OIDC_SUBJECT, identity.Provider, identity.Subject)
+				}
+				return
+			}
+			if !errors.Is(err, test.expectedError) {
+				t.Errorf("expected error %v, got %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestOIDCDiscoveryRejectsWrongIssuer(t *testing.T) {
+	fake := newFakeOIDC(t)
+	provider := newOIDCProvider("oidc", fake.server.URL+"/", "openid", oauthClient{client: fake.server.Client()})
+
+	if _, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "challenge"); err == nil {
+		t.Errorf("expected discovery to fail when the issuer doesn't match exactly")
+	}
+}
+
+// Mounts the routes a login or link flow goes through
+func newFlowMux(as *AuthService) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/login/{provider}", server.HandlerFunc(as.Login))
+	mux.Handle("GET /link/{provider}", server.HandlerFunc(as.Link))
+	mux.Handle("/callback/{provider}", server.HandlerFunc(as.Redirect))
+	return mux
+}
+
+// Plays the browser's part in a flow, end to end: starts it at path (e.g. /login/oidc) with the given cookies, follows the redirect to the
+// fake provider's /authorize, then brings the code back to the callback along with the cookies the flow set. Returns the callback's response.
+func runFlow(t *testing.T, fake *fakeOIDC, mux *http.ServeMux, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected %s to redirect, got status %d: %s", path, rec.Code, rec.Body.String())
+	}
+	flowCookies := rec.Result().Cookies()
+
+	client := fake.server.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }
+	res, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("could not reach authorize endpoint: %v", err)
+	}
+	res.Body.Close()
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("could not parse callback URL: %v", err)
+	}
+	req = httptest.NewRequest("GET", callback.RequestURI(), nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	for _, c := range flowCookies {
+		// like a browser would, drop cookies the response expired
+		if c.MaxAge >= 0 {
+			req.AddCookie(c)
+		}
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// Logs in through the auth service's own routes, end to end: /login/oidc, the provider's /authorize, then /callback/oidc
+func TestOIDCLoginEndToEnd(t *testing.T) {
+	fake := newFakeOIDC(t)
+	as := newTestAuthServiceWithDB(t)
+	as.providers = map[string]Provider{"oidc": fake.provider()}
+
+	rec := runFlow(t, fake, newFlowMux(as), "/login/oidc?return_to=/settings%3Ftab%3Daccount")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected callback to succeed, got status %d: %s", rec.Code, rec.Body.String())
+	}
+	if location := rec.Header().Get("Location"); location != "/settings?tab=account" {
+		t.Errorf("expected to be sent back to /settings?tab=account, got %s", location)
+	}
+
+	var issued int
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == ACCESS_COOKIE_NAME || c.Name == REFRESH_COOKIE_NAME {
+			issued++
+		}
+	}
+	if issued != 2 {
+		t.Errorf("expected access and refresh cookies to be issued, got %v", rec.Result().Cookies())
+	}
+
+	if n := countRows(t, as, "SELECT COUNT(*) FROM user_identities WHERE provider = 'oidc' AND subject = ? AND user_id != 1", OIDC_SUBJECT); n != 1 {
+		t.Errorf("expected a user to be created for the OIDC subject")
+	}
+}
@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"net/http"
+
+	"wingbox.spencrc/internal/problem"
+)
+
+// What the auth service's routes respond with when they can't do what was asked. Anything that went wrong on our end is problem.Internal.
+var (
+	problemUnknownProvider = problem.New(http.StatusNotFound, "unknown_provider", "unknown login provider")
+	problemInvalidState    = problem.New(http.StatusBadRequest, "invalid_state", "this login wasn't started here or has expired, try logging in again")
+	problemProviderFailed  = problem.New(http.StatusBadGateway, "provider_failed", "the login provider couldn't be reached, try again later")
+	problemLinkedElsewhere = problem.New(http.StatusConflict, "linked_elsewhere", ErrIdentityLinkedElsewhere.Error())
+	problemInvalidToken    = problem.New(http.StatusUnauthorized, "invalid_token", "invalid refresh token")
+	problemTokenRevoked    = problem.New(http.StatusUnauthorized, "token_revoked", ErrRefreshTokenRevoked.Error())
+	problemTokenReused     = problem.New(http.StatusUnauthorized, "token_reused", ErrRefreshTokenReused.Error())
+	// the request that used it will have set new cookies by now, so whatever needed refreshing can just be retried
+	problemTokenJustUsed   = problem.New(http.StatusConflict, "token_just_used", ErrRefreshTokenJustUsed.Error())
+	problemIdentityMissing = problem.New(http.StatusNotFound, "identity_not_found", ErrIdentityNotFound.Error())
+	problemLastIdentity    = problem.New(http.StatusConflict, "last_identity", ErrLastIdentity.Error())
+)
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"wingbox.spencrc/internal/tracing"
+)
+
+type TokenRes struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	// only sent by OpenID Connect providers
+	IDToken string `json:"id_token"`
+}
+
+// Who the user is, according to a provider. Subject is the provider's ID for them, and is only unique within that provider.
+type Identity struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+}
+
+// An OAuth provider users can log in with, e.g. Discord
+type Provider interface {
+	// Short name used in routes (/login/{provider}) and stored with the user's identity, e.g. "discord"
+	Name() string
+	// Builds the URL to send the user to, so they can log in with the provider. Providers that support it should bind the nonce to their tokens.
+	// codeChallenge is the S256 PKCE challenge for the verifier that will later be passed to Exchange.
+	AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error)
+	// Trades the code the provider redirected back with for the provider's tokens, proving with the PKCE verifier that we started the login
+	Exchange(ctx context.Context, code string, verifier string) (TokenRes, error)
+	// Fetches who the tokens belong to. nonce is the one passed to AuthCodeURL, so providers that bound it to their tokens can check it.
+	Identity(ctx context.Context, tokens TokenRes, nonce string) (Identity, error)
+}
+
+// Settings every OAuth provider needs, regardless of who it is
+type oauthClient struct {
+	clientId     string
+	clientSecret string
+	redirectURI  string
+	client       *http.Client
+}
+
+// Sends the request passed, checks if it responded OK, then decodes (with result put into passed data argument). Returns error.
+// Due to how decoding works, data must be passed as a pointer!
+func fetch[T any](client *http.Client, req *http.Request, data *T) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("api returned unexpected status %d", res.StatusCode)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(data)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Builds the standard OAuth authorization URL for the provider's authorize endpoint, with a PKCE challenge.
+// Providers that don't support PKCE ignore the challenge, so it's always sent.
+func authCodeURL(authorizeURL string, oc oauthClient, scope string, state string, codeChallenge string) string {
+	params := url.Values{
+		"client_id":             {oc.clientId},
+		"response_type":         {"code"},
+		"redirect_uri":          {oc.redirectURI},
+		"scope":                 {scope},
+		"state":                 {state},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	return fmt.Sprintf("%s?%s", authorizeURL, params.Encode())
+}
+
+// Builds the standard OAuth request to trade a code for tokens at the provider's token endpoint, then fetches a response.
+// On failure, returns empty TokenRes and error.
+// On success, returns decoded response as TokenRes and nil.
+func exchangeCode(ctx context.Context, tokenURL string, oc oauthClient, code string, verifier string) (TokenRes, error) {
+	body := url.Values{}
+	body.Set("grant_type", "authorization_code")
+	body.Set("code", code)
+	body.Set("code_verifier", verifier)
+	body.Set("redirect_uri", oc.redirectURI)
+	body.Set("client_id", oc.clientId)
+	body.Set("client_secret", oc.clientSecret)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(body.Encode()))
+	if err != nil {
+		return TokenRes{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// some providers (GitHub!) respond with a form-encoded body unless asked not to
+	req.Header.Set("Accept", "application/json")
+
+	var tokenData TokenRes
+	if err = fetch(oc.client, req, &tokenData); err != nil {
+		return TokenRes{}, err
+	}
+
+	return tokenData, nil
+}
+
+// Builds a GET request for a provider API that authenticates with the user's access token
+func newBearerRequest(ctx context.Context, url string, tokens TokenRes) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokens.AccessToken))
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// Builds the provider's OAuth client from its config. The redirect URI is the provider's callback route under OAUTH_REDIRECT_BASE_URL.
+// Returns false if the provider isn't configured, i.e. it has no client ID.
+func newOAuthClient(cfg OAuthClientConfig, name string, redirectBaseURL string) (oauthClient, bool) {
+	if cfg.ClientID == "" {
+		return oauthClient{}, false
+	}
+
+	return oauthClient{
+		clientId:     cfg.ClientID,
+		clientSecret: cfg.ClientSecret.Reveal(),
+		redirectURI:  strings.TrimSuffix(redirectBaseURL, "/") + "/" + name,
+		client:       tracing.NewHTTPClient(PROVIDER_TIMEOUT),
+	}, true
+}
+
+// Sets up every provider that has a client ID configured, keyed by name
+func loadProviders(cfg Config) map[string]Provider {
+	providers := map[string]Provider{}
+
+	if oc, ok := newOAuthClient(cfg.Discord, "discord", cfg.RedirectBaseURL); ok {
+		providers["discord"] = &discordProvider{oc}
+	}
+	if oc, ok := newOAuthClient(cfg.GitHub, "github", cfg.RedirectBaseURL); ok {
+		providers["github"] = &githubProvider{oc}
+	}
+	if oc, ok := newOAuthClient(cfg.OIDC.Client, cfg.OIDC.Name, cfg.RedirectBaseURL); ok {
+		providers[cfg.OIDC.Name] = newOIDCProvider(cfg.OIDC.Name, cfg.OIDC.Issuer, cfg.OIDC.Scopes, oc)
+	}
+
+	return providers
+}
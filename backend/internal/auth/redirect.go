@@ -1,203 +1,106 @@
 package auth
 
 import (
-	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
-	"strconv"
-	"strings"
-	"time"
-
-	"github.com/google/uuid"
 )
 
-type TokenRes struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-}
-
-type UserRes struct {
-	UserId string `json:"id"`
-}
-
 var ErrInvalidState error = errors.New("the provided state code is invalid") 
 var ErrMissingCode error = errors.New("code is missing from query parameters")
 
-// Gets oauth state from cookie, checks its validity, then gets the code to obtain Discord access tokens.
-// On failure, returns empty string and error.
-// On success, returns code string and nil.
-func redeemCodeFromCookie(r *http.Request) (string, error) {
-	cookie, err := r.Cookie("oauth_state")
-	if err != nil {
-		return "", err
-	}
-
-	state := r.URL.Query().Get("state")
-	if state != cookie.Value {
-		return "", ErrInvalidState
-	}
-
-	code := r.URL.Query().Get("code")
-	if code == "" {
-		return "", ErrMissingCode
-	}
-
-	return code, nil
-}
+// Finishes logging in once the provider sends the user back: trades the code for the provider's tokens, finds out who the user is, then issues
+// our own access and refresh tokens, and sends the user back to where they started. If the user started at Link instead, the identity is
+// linked to them and no new tokens are issued.
+func (as *AuthService) Redirect(w http.ResponseWriter, r *http.Request) error {
+	db := as.server.Db
 
-// Sends the request passed, checks if it responded OK, then decodes (with result put into passed data argument). Returns error.
-// Due to how decoding works, data must be passed as a pointer!
-func fetch[T TokenRes | UserRes](client *http.Client, req *http.Request, data *T) error {
-	res, err := client.Do(req)
+	provider, err := as.providerFromPath(r)
 	if err != nil {
 		return err
 	}
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("api returned unexpected status %d", res.StatusCode)
-	}
 
-	err = json.NewDecoder(res.Body).Decode(data)
+	// counted as a link or a login once we know which it is, from the flow cookie
+	result := RESULT_ERROR
+	flow, code, err := as.redeemFlow(r)
+	defer func() {
+		counter := as.metrics.logins
+		if flow.link {
+			counter = as.metrics.links
+		}
+		counter.WithLabelValues(provider.Name(), result).Inc()
+	}()
 	if err != nil {
-		return err
+		result = RESULT_INVALID_STATE
+		return fmt.Errorf("could not redeem oauth flow for provider %s: %w: %w", provider.Name(), problemInvalidState, err)
 	}
+	clearFlowCookie(w)
 
-	return nil
-}
-
-// Builds request to obtain Discord access token, then fetches a response
-// On failure, returns empty TokenRes and error.
-// On success, returns decoded response as TokenRes and nil.
-func fetchTokenData(code string, redirectURI string, clientId string, clientSecret string, client *http.Client) (TokenRes, error) {
-	body := url.Values{}
-	body.Set("grant_type", "authorization_code")
-	body.Set("code", code)
-	body.Set("redirect_uri", redirectURI)
-	body.Set("client_id", clientId)
-	body.Set("client_secret", clientSecret)
-
-	req, err := http.NewRequest(http.MethodPost, DISCORD_BASE_URL + "/api/oauth2/token", strings.NewReader(body.Encode()))
+	tokenData, err := provider.Exchange(r.Context(), code, flow.verifier)
 	if err != nil {
-		return TokenRes{}, err
+		result = RESULT_PROVIDER_FAILED
+		return fmt.Errorf("could not fetch token from provider %s: %w: %w", provider.Name(), problemProviderFailed, err)
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	var tokenData TokenRes
-	if err = fetch(client, req, &tokenData); err != nil {
-		return TokenRes{}, err
-	}
-
-	return tokenData, nil
-}
-
-// Builds request to obtain current Discord user data, then fetches a response.
-// On failure, returns empty UserRes and error.
-// On success, returns decoded response as UserRes and nil.
-func fetchDiscordUserData(tokenData TokenRes, client *http.Client) (UserRes, error) {
-	req, err := http.NewRequest(http.MethodGet, DISCORD_BASE_URL + "/api/users/@me", nil)
+	identity, err := provider.Identity(r.Context(), tokenData, flow.nonce)
 	if err != nil {
-		return UserRes{}, err
+		result = RESULT_PROVIDER_FAILED
+		return fmt.Errorf("failed to fetch user data from provider %s: %w: %w", provider.Name(), problemProviderFailed, err)
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenData.AccessToken))
 
-	var userData UserRes
-	err = fetch(client, req, &userData)
-	if err != nil {
-		return UserRes{}, err
+	if flow.link {
+		result, err = as.finishLink(w, r, flow, identity)
+		return err
 	}
 
-	return userData, err
-}
-
-// Inserts into the database by Discord ID, and, if it returns no rows (due to Discord ID being unqiue), then finds row with matching Discord ID. Returns app's user ID.
-// Could be done in one query by upserting instead; however, my understanding is that it will bottleneck concurrency by write locking when it should not
-func ensureUser(db *sql.DB, discordID string, userID *uint64) error {
-	const query = `
-		INSERT INTO users (discord_id)
-		VALUES (?)
-		ON CONFLICT(discord_id) DO NOTHING
-		RETURNING id;
-	`
-	insertErr := db.QueryRow(query, discordID).Scan(userID)
-	switch insertErr {
-	case sql.ErrNoRows:
-		if selectErr := db.QueryRow("SELECT id FROM users WHERE discord_id =?", discordID).Scan(userID); selectErr != nil {
-			return selectErr
-		}
-	default:
-		return insertErr
+	var sub string
+	if err = ensureUser(r.Context(), db, identity, &sub); err != nil {
+		return fmt.Errorf("failed to insert or find user into database: %w", err)
 	}
-	return nil
-}
 
-func (as *AuthService) Redirect(w http.ResponseWriter, r *http.Request) {
-	logger := as.server.Logger
-	db := as.server.Db
-	client := &http.Client{}
+	tokens := newTokenPair(sub)
 
-	code, err := redeemCodeFromCookie(r)
+	tx, err := db.BeginTx(r.Context(), nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	tokenData, err := fetchTokenData(code, as.redirectURI, as.clientId, as.clientSecret, client)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		logger.Error("could not fetch token from Discord", "err", err)
-		return
+	if err = insertRefreshToken(r.Context(), tx, tokens, as.cfg.RefreshTokenTTL); err != nil {
+		return fmt.Errorf("failed to insert refresh token into database: %w", err)
 	}
 
-	discordUserData, err := fetchDiscordUserData(tokenData, client)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		logger.Error("failed to fetch user data from Discord", "err", err)
-		return
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit refresh token: %w", err)
 	}
 
-	var userID uint64
-	if err = ensureUser(db, discordUserData.UserId, &userID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		logger.Error("failed to insert or find user into database", "err", err)
-		return
+	if err = as.setTokenCookies(w, r, tokens); err != nil {
+		return fmt.Errorf("failed to set token cookies: %w", err)
 	}
 
-	accessJti := uuid.NewString()
-	sub := strconv.FormatUint(userID, 10)
-	accessClaims := map[string]string{
-		"jti": accessJti,
-		"sub": sub,
-	}
+	result = RESULT_SUCCESS
+	http.Redirect(w, r, safeReturnTo(flow.returnTo, as.cfg.ReturnToPrefixes), http.StatusFound)
+	return nil
+}
 
-	refreshJti := uuid.NewString()
-	refreshClaims := map[string]string{
-		"jti": refreshJti,
-		"sub": sub,
+// Links the identity the provider vouched for to the user whose session started the link. Returns the result to count it as.
+func (as *AuthService) finishLink(w http.ResponseWriter, r *http.Request, flow oauthFlow, identity Identity) (string, error) {
+	sub, err := as.sessionUser(r)
+	if errors.Is(err, ErrNotLoggedIn) {
+		return RESULT_NOT_LOGGED_IN, err
 	}
-
-	db.Exec(`
-		INSERT INTO refresh_tokens (jti, sub, expires_at)
-		VALUES (?, ?, ?);
-	`, refreshJti, sub, time.Now().Add(REFRESH_MAX_AGE).Unix())
-
-	err = as.accessMgr.SetJWTCookie(w, r, accessClaims)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		logger.Error("failed to set access token", "err", err)
-		return
+		return RESULT_ERROR, fmt.Errorf("failed to look up session: %w", err)
 	}
 
-	err = as.refreshMgr.SetJWTCookie(w, r, refreshClaims)
+	err = linkIdentity(r.Context(), as.server.Db, identity, sub)
+	if errors.Is(err, ErrIdentityLinkedElsewhere) {
+		return RESULT_LINKED_ELSEWHERE, problemLinkedElsewhere
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		logger.Error("failed to set refresh token", "err", err)
-		return
+		return RESULT_ERROR, fmt.Errorf("failed to link identity from provider %s: %w", identity.Provider, err)
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Access and refresh cookies set successfully"))
-}
\ No newline at end of file
+	http.Redirect(w, r, safeReturnTo(flow.returnTo, as.cfg.ReturnToPrefixes), http.StatusFound)
+	return RESULT_SUCCESS, nil
+}
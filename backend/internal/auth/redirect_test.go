@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -8,12 +9,17 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
-func TestRedeemCookie(t *testing.T) {
+func TestRedeemFlow(t *testing.T) {
+	as := newTestAuthService(t)
+
 	var tests = []struct{
 		name string
 		cookieValue string
+		forged bool
 		queryState string
 		queryCode string
 		expectedError error
@@ -39,6 +45,14 @@ func TestRedeemCookie(t *testing.T) {
 			queryCode:    "auth_code_123",
 			expectedError: http.ErrNoCookie,
 		},
+		{
+			name: "forged cookie",
+			cookieValue:  "secret_state",
+			forged:       true,
+			queryState:   "secret_state",
+			queryCode:    "auth_code_123",
+			expectedError: jwt.ErrTokenMalformed,
+		},
 		{
 			name: "missing code",
 			cookieValue:  "secret_state",
@@ -53,12 +67,19 @@ func TestRedeemCookie(t *testing.T) {
 			url := fmt.Sprintf("/redirect?state=%s&code=%s", test.queryState, test.queryCode)
 			req := httptest.NewRequest("GET", url, nil)
 
-			if test.cookieValue != "" {
-				cookie := generateStateCookie(test.cookieValue)
-				req.AddCookie(&cookie)
+			if test.forged {
+				req.AddCookie(&http.Cookie{Name: FLOW_COOKIE_NAME, Value: test.cookieValue})
+			} else if test.cookieValue != "" {
+				flow := newOAuthFlow(false, "/")
+				flow.state = test.cookieValue
+				rec := httptest.NewRecorder()
+				if err := as.setFlowCookie(rec, req, flow); err != nil {
+					t.Fatalf("could not set flow cookie: %v", err)
+				}
+				req.AddCookie(rec.Result().Cookies()[0])
 			}
 
-			code, err := redeemCodeFromCookie(req)
+			_, code, err := as.redeemFlow(req)
 			if !errors.Is(err, test.expectedError) {
 				t.Errorf("got error %v, but was expecting error %v! with cookie value %s, query state %s, and query code %s", err, test.expectedError, test.cookieValue, test.queryState, test.queryCode)
 			} else if code != test.queryCode && err == nil {
@@ -77,7 +98,7 @@ func (f RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
 const ACCESS_TOKEN = "mock_atoken_123"
 const REFRESH_TOKEN = "mock_rtoken_456"
 
-func TestFetchTokenData(t *testing.T) {
+func TestExchangeCode(t *testing.T) {
 
 	
 	body := fmt.Sprintf(`{
@@ -88,6 +109,9 @@ func TestFetchTokenData(t *testing.T) {
 	// see here: https://dev.to/andreidascalu/testing-your-api-client-in-go-a-method-4bm4
 	client := &http.Client{
 		Transport: RoundTripFunc(func(req *http.Request) *http.Response {
+			if err := req.ParseForm(); err != nil || req.PostForm.Get("code_verifier") != "verifier" {
+				t.Errorf("expected the PKCE code verifier to be sent, got form %v", req.PostForm)
+			}
 			return &http.Response{
 				StatusCode: 200,
 				Body: io.NopCloser(strings.NewReader(body)),
@@ -96,7 +120,7 @@ func TestFetchTokenData(t *testing.T) {
 		}),
 	}
 
-	res, err := fetchTokenData("code", "uri", "id", "secret", client)
+	res, err := exchangeCode(context.Background(), "https://example.com/token", oauthClient{"id", "secret", "uri", client}, "code", "verifier")
 	if err != nil {
 		t.Errorf("did not expect error, got %v", err)
 	} else if res.AccessToken != ACCESS_TOKEN {
@@ -106,28 +130,65 @@ func TestFetchTokenData(t *testing.T) {
 	}
 }
 
-func TestFetchDiscordUserData(t *testing.T) {
-	const USER_ID = "123456"
-	
-	body := fmt.Sprintf(`{
-		"id": "%s"
-	}`, USER_ID)
+func TestProviderIdentity(t *testing.T) {
+	var tests = []struct {
+		name            string
+		provider        func(client *http.Client) Provider
+		body            string
+		expectedSubject string
+		expectError     bool
+	}{
+		{
+			name:            "discord",
+			provider:        func(client *http.Client) Provider { return &discordProvider{oauthClient{client: client}} },
+			body:            `{"id": "123456"}`,
+			expectedSubject: "123456",
+		},
+		{
+			name:        "discord without ID",
+			provider:    func(client *http.Client) Provider { return &discordProvider{oauthClient{client: client}} },
+			body:        `{}`,
+			expectError: true,
+		},
+		{
+			name:            "github",
+			provider:        func(client *http.Client) Provider { return &githubProvider{oauthClient{client: client}} },
+			body:            `{"id": 654321, "login": "octocat"}`,
+			expectedSubject: "654321",
+		},
+	}
 
-	// see here: https://dev.to/andreidascalu/testing-your-api-client-in-go-a-method-4bm4
-	client := &http.Client{
-		Transport: RoundTripFunc(func(req *http.Request) *http.Response {
-			return &http.Response{
-				StatusCode: 200,
-				Body: io.NopCloser(strings.NewReader(body)),
-				Header: make(http.Header),
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// see here: https://dev.to/andreidascalu/testing-your-api-client-in-go-a-method-4bm4
+			client := &http.Client{
+				Transport: RoundTripFunc(func(req *http.Request) *http.Response {
+					if auth := req.Header.Get("Authorization"); auth != "Bearer "+ACCESS_TOKEN {
+						t.Errorf("expected request to be authorized with the access token, got %q", auth)
+					}
+					return &http.Response{
+						StatusCode: 200,
+						Body:       io.NopCloser(strings.NewReader(test.body)),
+						Header:     make(http.Header),
+					}
+				}),
 			}
-		}),
-	}
+			provider := test.provider(client)
 
-	res, err := fetchDiscordUserData(TokenRes{AccessToken: ACCESS_TOKEN}, client)
-	if err != nil {
-		t.Errorf("did not expect error, got %v", err)
-	} else if res.UserId != USER_ID {
-		t.Errorf("expected %s, got %s", USER_ID, res.UserId)
+			res, err := provider.Identity(context.Background(), TokenRes{AccessToken: ACCESS_TOKEN}, "")
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got identity %v", res)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("did not expect error, got %v", err)
+			} else if res.Subject != test.expectedSubject {
+				t.Errorf("expected subject %s, got %s", test.expectedSubject, res.Subject)
+			} else if res.Provider != provider.Name() {
+				t.Errorf("expected provider %s, got %s", provider.Name(), res.Provider)
+			}
+		})
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"wingbox.spencrc/internal/server"
+)
+
+var ErrRefreshTokenRevoked error = errors.New("refresh token is expired or has been revoked")
+var ErrRefreshTokenReused error = errors.New("refresh token has already been used")
+var ErrRefreshTokenJustUsed error = errors.New("refresh token was just used by another request")
+
+// How long after a refresh token is rotated out presenting it again is taken for a request that raced the one that rotated it, e.g. from
+// another tab, rather than it being replayed
+const REFRESH_REUSE_GRACE = 10 * time.Second
+
+// Marks the refresh token as consumed, but only if it's still there, unconsumed, and hasn't expired. The check and update happen in one statement, so
+// two requests racing with the same token can't both redeem it.
+// If the token was consumed within REFRESH_REUSE_GRACE, the other request is most likely the user's own, so ErrRefreshTokenJustUsed is returned
+// and nothing is revoked. If it was consumed before that, it has been replayed (likely stolen!), so every token in its family is deleted and
+// ErrRefreshTokenReused is returned. The caller must still commit the transaction in that case for the revocation to stick.
+// On failure, returns the token's family and ErrRefreshTokenRevoked, ErrRefreshTokenJustUsed or ErrRefreshTokenReused if it was not redeemable,
+// otherwise the database error.
+// On success, returns the token's family and nil.
+func redeemRefreshToken(ctx context.Context, tx *sql.Tx, jti string, sub string) (string, error) {
+	var family string
+	now := time.Now()
+	err := tx.QueryRowContext(ctx, `
+		UPDATE refresh_tokens SET consumed = 1, consumed_at = ?
+		WHERE jti = ? AND sub = ? AND consumed = 0 AND expires_at > ?
+		RETURNING family;
+	`, now.Unix(), jti, sub, now.Unix()).Scan(&family)
+	if err == nil {
+		return family, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	// couldn't redeem it, so find out why
+	var consumed bool
+	var consumedAt int64
+	err = tx.QueryRowContext(ctx, "SELECT family, consumed, consumed_at FROM refresh_tokens WHERE jti = ? AND sub = ?", jti, sub).Scan(&family, &consumed, &consumedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// already deleted, e.g. the family was revoked earlier
+		return "", ErrRefreshTokenRevoked
+	} else if err != nil {
+		return "", err
+	}
+	if !consumed {
+		return family, ErrRefreshTokenRevoked
+	}
+	if now.Sub(time.Unix(consumedAt, 0)) < REFRESH_REUSE_GRACE {
+		return family, ErrRefreshTokenJustUsed
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE family = ?", family); err != nil {
+		return family, err
+	}
+	return family, ErrRefreshTokenReused
+}
+
+// Trades a valid refresh token cookie for a new access and refresh token pair. The old refresh token is consumed in the same transaction the new one
+// is inserted in, so it can only ever be used once. Presenting it again, once REFRESH_REUSE_GRACE has passed, revokes every token descended from
+// the same login.
+// Also clears out refresh tokens that have expired, as they're no longer needed.
+func (as *AuthService) Refresh(w http.ResponseWriter, r *http.Request) error {
+	db := as.server.Db
+
+	result := RESULT_ERROR
+	defer func() {
+		as.metrics.refreshes.WithLabelValues(result).Inc()
+	}()
+
+	claims, err := as.refreshMgr().GetClaimsOfValid(r)
+	if err != nil {
+		result = RESULT_INVALID_TOKEN
+		return problemInvalidToken
+	}
+
+	sub, jti, ok := subAndJti(claims)
+	if !ok {
+		result = RESULT_INVALID_TOKEN
+		return problemInvalidToken
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	family, err := redeemRefreshToken(r.Context(), tx, jti, sub)
+	if errors.Is(err, ErrRefreshTokenReused) {
+		result = RESULT_REUSED
+		logger := server.LoggerFrom(r.Context())
+		// commit so the family revocation actually happens
+		if commitErr := tx.Commit(); commitErr != nil {
+			logger.Error("failed to commit refresh token family revocation", "err", commitErr, "family", family)
+		}
+		logger.Warn("security event: refresh token reuse detected, revoked token family",
+			"sub", sub, "jti", jti, "family", family, "remote_addr", r.RemoteAddr, "user_agent", r.UserAgent())
+		return problemTokenReused
+	} else if errors.Is(err, ErrRefreshTokenJustUsed) {
+		result = RESULT_JUST_USED
+		return problemTokenJustUsed
+	} else if errors.Is(err, ErrRefreshTokenRevoked) {
+		result = RESULT_REVOKED
+		return problemTokenRevoked
+	} else if err != nil {
+		return fmt.Errorf("failed to redeem refresh token: %w", err)
+	}
+
+	// consumed tokens are kept so replaying them can be caught, but once expired their cookies are refused before getting this far, so
+	// they, and any expired tokens never used, are cleared out here rather than left to pile up
+	if _, err = tx.ExecContext(r.Context(), "DELETE FROM refresh_tokens WHERE expires_at <= ?", time.Now().Unix()); err != nil {
+		return fmt.Errorf("failed to delete expired refresh tokens: %w", err)
+	}
+
+	tokens := newTokenPair(sub)
+	tokens.family = family
+	if err = insertRefreshToken(r.Context(), tx, tokens, as.cfg.RefreshTokenTTL); err != nil {
+		return fmt.Errorf("failed to insert refresh token into database: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit refresh token rotation: %w", err)
+	}
+
+	if err = as.setTokenCookies(w, r, tokens); err != nil {
+		return fmt.Errorf("failed to set token cookies: %w", err)
+	}
+
+	result = RESULT_SUCCESS
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
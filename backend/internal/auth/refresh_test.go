@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	_ "modernc.org/sqlite"
+	"wingbox.spencrc/internal/migrate"
+	"wingbox.spencrc/internal/server"
+)
+
+// Opens a fresh in-memory database with the tables the auth service needs, and a user with ID 1 in it, who logs in with Discord
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", "file::memory:?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	// every connection to :memory: is its own database, so make sure we only ever have one
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := migrate.Migrations()
+	if err != nil {
+		t.Fatalf("could not load migrations: %v", err)
+	}
+	if _, err = migrate.Up(context.Background(), db, migrations); err != nil {
+		t.Fatalf("could not migrate database: %v", err)
+	}
+	if _, err = db.Exec("INSERT INTO users (id) VALUES (1); INSERT INTO user_identities (user_id, provider, subject) VALUES (1, 'discord', '1234')"); err != nil {
+		t.Fatalf("could not insert user: %v", err)
+	}
+
+	return db
+}
+
+// Same as newTestAuthService, but with a server (and so a database) attached
+func newTestAuthServiceWithDB(t *testing.T) *AuthService {
+	as := newTestAuthService(t)
+	as.server = &server.Server{
+		Logger: slog.New(slog.DiscardHandler),
+		Db:     newTestDB(t),
+	}
+	return as
+}
+
+// Issues a refresh token cookie for the given jti and sub, and records it in the database as expiring at expiresAt
+func issueRefreshCookie(t *testing.T, as *AuthService, jti string, sub string, expiresAt time.Time) *http.Cookie {
+	_, err := as.server.Db.Exec("INSERT INTO refresh_tokens (jti, sub, expires_at, family) VALUES (?, ?, ?, ?)", jti, sub, expiresAt.Unix(), jti)
+	if err != nil {
+		t.Fatalf("could not insert refresh token: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	err = as.refreshMgr().SetJWTCookie(rec, httptest.NewRequest("POST", "/", nil), map[string]string{"jti": jti, "sub": sub})
+	if err != nil {
+		t.Fatalf("could not set refresh cookie: %v", err)
+	}
+	return rec.Result().Cookies()[0]
+}
+
+func refresh(as *AuthService, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/refresh", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	server.HandlerFunc(as.Refresh).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRefreshRotates(t *testing.T) {
+	as := newTestAuthServiceWithDB(t)
+	cookie := issueRefreshCookie(t, as, "old-jti", "1", time.Now().Add(time.Hour))
+
+	rec := refresh(as, cookie)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body.String())
+	}
+	if n := len(rec.Result().Cookies()); n != 2 {
+		t.Errorf("expected access and refresh cookies to be set, got %d cookies", n)
+	}
+
+	var count int
+	as.server.Db.QueryRow("SELECT COUNT(*) FROM refresh_tokens WHERE jti = 'old-jti' AND consumed = 1").Scan(&count)
+	if count != 1 {
+		t.Errorf("expected old refresh token to be marked as consumed")
+	}
+	as.server.Db.QueryRow("SELECT COUNT(*) FROM refresh_tokens WHERE family = 'old-jti' AND consumed = 0").Scan(&count)
+	if count != 1 {
+		t.Errorf("expected exactly one unconsumed refresh token in the family after rotation, got %d", count)
+	}
+
+	// the rotated token should keep working
+	var rotated *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == cookie.Name {
+			rotated = c
+		}
+	}
+	if rec = refresh(as, rotated); rec.Code != http.StatusNoContent {
+		t.Errorf("expected rotated refresh token to get status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	as := newTestAuthServiceWithDB(t)
+	stolen := issueRefreshCookie(t, as, "stolen-jti", "1", time.Now().Add(time.Hour))
+	other := issueRefreshCookie(t, as, "other-jti", "1", time.Now().Add(time.Hour))
+
+	rec := refresh(as, stolen)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body.String())
+	}
+
+	// replaying the consumed token once the grace period is over should be refused...
+	as.server.Db.Exec("UPDATE refresh_tokens SET consumed_at = ? WHERE jti = 'stolen-jti'", time.Now().Add(-REFRESH_REUSE_GRACE).Unix())
+	if rec = refresh(as, stolen); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected replayed refresh token to get status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	// ...and take the rest of its family with it
+	var count int
+	as.server.Db.QueryRow("SELECT COUNT(*) FROM refresh_tokens WHERE family = 'stolen-jti'").Scan(&count)
+	if count != 0 {
+		t.Errorf("expected every token in the family to be revoked, %d remain", count)
+	}
+
+	// but other logins (families) should be left alone
+	if rec = refresh(as, other); rec.Code != http.StatusNoContent {
+		t.Errorf("expected token from another family to get status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+
+	if n := testutil.ToFloat64(as.metrics.refreshes.WithLabelValues(RESULT_SUCCESS)); n != 2 {
+		t.Errorf("expected 2 successful refreshes to be counted, got %v", n)
+	}
+	if n := testutil.ToFloat64(as.metrics.refreshes.WithLabelValues(RESULT_REUSED)); n != 1 {
+		t.Errorf("expected the replay to be counted as reused, got %v", n)
+	}
+}
+
+func TestRefreshConcurrent(t *testing.T) {
+	as := newTestAuthServiceWithDB(t)
+	cookie := issueRefreshCookie(t, as, "shared-jti", "1", time.Now().Add(time.Hour))
+
+	// e.g. two tabs whose access tokens expired at the same time
+	codes := make(chan int, 2)
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Go(func() { codes <- refresh(as, cookie).Code })
+	}
+	wg.Wait()
+	close(codes)
+
+	var statuses []int
+	for code := range codes {
+		statuses = append(statuses, code)
+	}
+	slices.Sort(statuses)
+	if !slices.Equal(statuses, []int{http.StatusNoContent, http.StatusConflict}) {
+		t.Fatalf("expected one refresh to rotate the token and the other to be told it was just used, got %v", statuses)
+	}
+
+	var count int
+	as.server.Db.QueryRow("SELECT COUNT(*) FROM refresh_tokens WHERE family = 'shared-jti' AND consumed = 0").Scan(&count)
+	if count != 1 {
+		t.Errorf("expected the token the first refresh issued to survive the second, got %d unconsumed", count)
+	}
+	if n := testutil.ToFloat64(as.metrics.refreshes.WithLabelValues(RESULT_REUSED)); n != 0 {
+		t.Errorf("expected racing refreshes not to be counted as reuse, got %v", n)
+	}
+}
+
+func TestRefreshDeletesExpired(t *testing.T) {
+	as := newTestAuthServiceWithDB(t)
+	cookie := issueRefreshCookie(t, as, "current-jti", "1", time.Now().Add(time.Hour))
+	_, err := as.server.Db.Exec(`
+		INSERT INTO refresh_tokens (jti, sub, expires_at, family, consumed) VALUES
+			('expired-consumed', 1, ?, 'expired-consumed', 1),
+			('expired-unused', 1, ?, 'expired-unused', 0);
+	`, time.Now().Add(-time.Minute).Unix(), time.Now().Add(-time.Hour).Unix())
+	if err != nil {
+		t.Fatalf("could not insert expired refresh tokens: %v", err)
+	}
+
+	if rec := refresh(as, cookie); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body.String())
+	}
+
+	var count int
+	as.server.Db.QueryRow("SELECT COUNT(*) FROM refresh_tokens WHERE jti LIKE 'expired-%'").Scan(&count)
+	if count != 0 {
+		t.Errorf("expected expired refresh tokens to be deleted, %d remain", count)
+	}
+	// the consumed token is still needed to catch it being replayed
+	as.server.Db.QueryRow("SELECT COUNT(*) FROM refresh_tokens WHERE family = 'current-jti'").Scan(&count)
+	if count != 2 {
+		t.Errorf("expected the consumed and rotated tokens to be kept, got %d", count)
+	}
+}
+
+func TestRefreshRejects(t *testing.T) {
+	as := newTestAuthServiceWithDB(t)
+
+	var tests = []struct {
+		name   string
+		cookie *http.Cookie
+	}{
+		{
+			name:   "expired in database",
+			cookie: issueRefreshCookie(t, as, "expired-jti", "1", time.Now().Add(-time.Minute)),
+		},
+		{
+			name:   "access token instead of refresh token",
+			cookie: issueAccessCookie(t, as, map[string]string{"jti": "access-jti", "sub": "1"}),
+		},
+		{
+			name:   "tampered token",
+			cookie: &http.Cookie{Name: REFRESH_COOKIE_NAME, Value: "not.a.token"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if rec := refresh(as, test.cookie); rec.Code != http.StatusUnauthorized {
+				t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+			}
+		})
+	}
+}
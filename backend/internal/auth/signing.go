@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	jwtcookie "github.com/stfsy/go-jwt-cookie"
+	"wingbox.spencrc/internal/jwks"
+	"wingbox.spencrc/internal/server"
+)
+
+var ErrUnsupportedSigningAlg error = errors.New("JWT_SIGNING_ALG must be HS256 or ES256")
+
+// One key tokens are signed with: an HS256 secret or an ES256 private key
+type signingKey struct {
+	secret  []byte
+	private *ecdsa.PrivateKey
+}
+
+// What our tokens are signed and validated with. With HS256, anything that verifies tokens must also hold the secret to sign them, so ES256
+// is preferred wherever more than the auth service needs to verify them: its public keys are published at /.well-known/jwks.json instead.
+// Only the active key signs, but tokens signed by any accepted key are valid, so keys can be rotated without logging everyone out. see keyring.go
+type signingKeys struct {
+	method jwt.SigningMethod
+	// HS256 only. The cookie manager derives each secret's kid with it, so the kid doesn't give away anything about the secret
+	salt   []byte
+	active signingKey
+	// when active stops signing, if it ever does. see checkActive
+	activeNotAfter *time.Time
+	// every key tokens are accepted from, including active
+	accepted []signingKey
+}
+
+// Returns ErrNoActiveKey once the active key is past its not_after at now, which the keyring watcher keeps around rather than having no keys
+// at all, so nothing is signed with it any more
+func (k signingKeys) checkActive(now time.Time) error {
+	if k.activeNotAfter != nil && !now.Before(*k.activeNotAfter) {
+		return ErrNoActiveKey
+	}
+	return nil
+}
+
+func newHMACKeys(secret []byte, salt []byte) signingKeys {
+	key := signingKey{secret: secret}
+	return signingKeys{method: jwt.SigningMethodHS256, salt: salt, active: key, accepted: []signingKey{key}}
+}
+
+func newECDSAKeys(private *ecdsa.PrivateKey) signingKeys {
+	key := signingKey{private: private}
+	return signingKeys{method: jwt.SigningMethodES256, active: key, accepted: []signingKey{key}}
+}
+
+// Reads the P-256 private key from a PEM file, either PKCS #8 ("PRIVATE KEY") or SEC 1 ("EC PRIVATE KEY"), as made by e.g.
+// openssl genpkey -algorithm EC -pkeyopt ec_paramgen_curve:P-256
+func loadECDSAKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not PEM encoded", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s holds a %s, expected a private key", path, block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ecKey.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("%s is not a P-256 private key", path)
+	}
+	return ecKey, nil
+}
+
+// Loads the signing keys cfg sets up. If JWT_KEYRING_DIR is set, they're loaded from the keyring there. Otherwise there's just the one key,
+// and JWT_SIGNING_ALG picks the algorithm: HS256 signs with JWT_SECRET and JWT_SALT, ES256 with the PEM private key at JWT_PRIVATE_KEY_PATH.
+func loadSigningKeys(cfg SigningConfig) (signingKeys, error) {
+	if cfg.KeyringDir != "" {
+		return loadKeyring(cfg.KeyringDir, []byte(cfg.Salt.Reveal()), time.Now())
+	}
+
+	switch alg := cfg.Alg; alg {
+	case "HS256":
+		return newHMACKeys([]byte(cfg.Secret.Reveal()), []byte(cfg.Salt.Reveal())), nil
+	case "ES256":
+		key, err := loadECDSAKey(cfg.PrivateKeyPath)
+		if err != nil {
+			return signingKeys{}, err
+		}
+		return newECDSAKeys(key), nil
+	default:
+		return signingKeys{}, fmt.Errorf("%w, got %q", ErrUnsupportedSigningAlg, alg)
+	}
+}
+
+// Cookie manager options to sign with the active key and validate with every accepted one. The cookie manager sets each token's kid
+// header from the key that signed it, and uses it to pick the key to validate with.
+func (k signingKeys) cookieOptions() []jwtcookie.Option {
+	if k.method == jwt.SigningMethodES256 {
+		public := make([]*ecdsa.PublicKey, 0, len(k.accepted))
+		for _, key := range k.accepted {
+			public = append(public, &key.private.PublicKey)
+		}
+		return []jwtcookie.Option{
+			jwtcookie.WithSigningKeyECDSA(k.active.private),
+			jwtcookie.WithValidationKeysECDSA(public),
+			jwtcookie.WithSigningMethod(k.method),
+		}
+	}
+
+	secrets := make([][]byte, 0, len(k.accepted))
+	for _, key := range k.accepted {
+		secrets = append(secrets, key.secret)
+	}
+	return []jwtcookie.Option{
+		jwtcookie.WithSigningKeyHMAC(k.active.secret, k.salt),
+		jwtcookie.WithValidationKeysHMAC(secrets),
+		jwtcookie.WithSigningMethod(k.method),
+	}
+}
+
+// The public keys tokens can be verified with, i.e. every accepted key, so verifiers already know a staged key by the time it's activated.
+// Empty with HS256, as there's nothing that can safely be published.
+func (k signingKeys) jwks() (jwks.Set, error) {
+	set := jwks.Set{Keys: []jwks.Key{}}
+	if k.method != jwt.SigningMethodES256 {
+		return set, nil
+	}
+
+	for _, accepted := range k.accepted {
+		key, err := jwks.FromPublicKey(&accepted.private.PublicKey, k.method.Alg())
+		if err != nil {
+			return jwks.Set{}, err
+		}
+		set.Keys = append(set.Keys, key)
+	}
+	return set, nil
+}
+
+// Serves the public keys tokens are signed with as a JWKS, so other services can verify tokens without being able to sign them
+func (as *AuthService) JWKS(w http.ResponseWriter, r *http.Request) error {
+	set, err := as.managers().keys.jwks()
+	if err != nil {
+		return fmt.Errorf("failed to build JWKS: %w", err)
+	}
+
+	// verifiers refetch when they see a kid they don't know, so this only delays them noticing a key was removed
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	return server.WriteJSON(w, http.StatusOK, set)
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"wingbox.spencrc/internal/jwks"
+	"wingbox.spencrc/internal/server"
+)
+
+func newTestECDSAKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	return key
+}
+
+func fetchJWKS(t *testing.T, as *AuthService) jwks.Set {
+	rec := httptest.NewRecorder()
+	server.HandlerFunc(as.JWKS).ServeHTTP(rec, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var set jwks.Set
+	if err := json.NewDecoder(rec.Body).Decode(&set); err != nil {
+		t.Fatalf("could not decode JWKS: %v", err)
+	}
+	return set
+}
+
+// A service holding only the published JWKS should be able to verify access tokens, without ever seeing the private key
+func TestES256TokensVerifyAgainstJWKS(t *testing.T) {
+	as := newTestAuthServiceWithKeys(t, newECDSAKeys(newTestECDSAKey(t)))
+	as.server = &server.Server{Logger: slog.New(slog.DiscardHandler)}
+
+	set := fetchJWKS(t, as)
+	if len(set.Keys) != 1 {
+		t.Fatalf("expected 1 published key, got %d", len(set.Keys))
+	}
+	publicKeys := map[string]any{}
+	for _, k := range set.Keys {
+		pub, err := k.PublicKey()
+		if err != nil {
+			t.Fatalf("could not read published key: %v", err)
+		}
+		publicKeys[k.Kid] = pub
+	}
+
+	cookie := issueAccessCookie(t, as, map[string]string{"sub": "1", "jti": "abc-123"})
+	token, err := jwt.NewParser(jwt.WithValidMethods([]string{"ES256"})).Parse(cookie.Value, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		pub, ok := publicKeys[kid]
+		if !ok {
+			t.Fatalf("token's kid %q is not in the JWKS", kid)
+		}
+		return pub, nil
+	})
+	if err != nil || !token.Valid {
+		t.Fatalf("expected token to verify with the published key, got %v", err)
+	}
+
+	// and tokens signed by some other key shouldn't get through the auth service itself either
+	other := newTestAuthServiceWithKeys(t, newECDSAKeys(newTestECDSAKey(t)))
+	req := httptest.NewRequest("GET", "/verify", nil)
+	req.AddCookie(issueAccessCookie(t, other, map[string]string{"sub": "1", "jti": "abc-123"}))
+	if _, err = as.accessMgr().GetClaimsOfValid(req); err == nil {
+		t.Errorf("expected token signed by another key to be refused")
+	}
+}
+
+func TestHS256PublishesNoKeys(t *testing.T) {
+	as := newTestAuthService(t)
+	as.server = &server.Server{Logger: slog.New(slog.DiscardHandler)}
+
+	if set := fetchJWKS(t, as); len(set.Keys) != 0 {
+		t.Errorf("expected no keys to be published for HS256, got %v", set.Keys)
+	}
+}
+
+func TestLoadECDSAKey(t *testing.T) {
+	key := newTestECDSAKey(t)
+	dir := t.TempDir()
+
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(key)
+	sec1, _ := x509.MarshalECPrivateKey(key)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	wrongCurve, _ := x509.MarshalPKCS8PrivateKey(p384)
+
+	var tests = []struct {
+		name      string
+		pemType   string
+		der       []byte
+		expectErr bool
+	}{
+		{name: "PKCS #8", pemType: "PRIVATE KEY", der: pkcs8},
+		{name: "SEC 1", pemType: "EC PRIVATE KEY", der: sec1},
+		{name: "wrong curve", pemType: "PRIVATE KEY", der: wrongCurve, expectErr: true},
+		{name: "not a private key", pemType: "PUBLIC KEY", der: pkcs8, expectErr: true},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, strconv.Itoa(i)+".pem")
+			if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: test.pemType, Bytes: test.der}), 0600); err != nil {
+				t.Fatalf("could not write key: %v", err)
+			}
+
+			loaded, err := loadECDSAKey(path)
+			if test.expectErr {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("did not expect error, got %v", err)
+			}
+			if !loaded.Equal(key) {
+				t.Errorf("loaded key does not match the one written")
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Claims for a freshly issued access and refresh token pair belonging to the same user
+type tokenPair struct {
+	sub        string
+	accessJti  string
+	refreshJti string
+	// the refresh token family this pair belongs to. see redeemRefreshToken
+	family string
+}
+
+// Creates a token pair that starts a new refresh token family, e.g. on login. Rotations should carry the old token's family over instead.
+func newTokenPair(sub string) tokenPair {
+	refreshJti := uuid.NewString()
+	return tokenPair{
+		sub:        sub,
+		accessJti:  uuid.NewString(),
+		refreshJti: refreshJti,
+		family:     refreshJti,
+	}
+}
+
+// Pulls the sub and jti claims out of validated claims. Returns false if either of them is missing or empty.
+func subAndJti(claims jwt.MapClaims) (string, string, bool) {
+	sub, subOk := claims["sub"].(string)
+	jti, jtiOk := claims["jti"].(string)
+	if !subOk || !jtiOk || sub == "" || jti == "" {
+		return "", "", false
+	}
+	return sub, jti, true
+}
+
+// Records the pair's refresh token in the database, so it can later be redeemed at /refresh.
+// Takes a transaction so callers can make this atomic with whatever else they're doing (e.g. rotating out an old token).
+func insertRefreshToken(ctx context.Context, tx *sql.Tx, tokens tokenPair, ttl time.Duration) error {
+	expiresAt := time.Now().Add(ttl).Unix()
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO refresh_tokens (jti, sub, expires_at, family)
+		VALUES (?, ?, ?, ?);
+	`, tokens.refreshJti, tokens.sub, expiresAt, tokens.family)
+	return err
+}
+
+// Signs the pair and sets both as cookies on the response. Should only be called once the refresh token is committed to the database!
+func (as *AuthService) setTokenCookies(w http.ResponseWriter, r *http.Request, tokens tokenPair) error {
+	if err := as.managers().keys.checkActive(time.Now()); err != nil {
+		return err
+	}
+
+	err := as.accessMgr().SetJWTCookie(w, r, map[string]string{
+		"jti": tokens.accessJti,
+		"sub": tokens.sub,
+	})
+	if err != nil {
+		return err
+	}
+
+	return as.refreshMgr().SetJWTCookie(w, r, map[string]string{
+		"jti": tokens.refreshJti,
+		"sub": tokens.sub,
+	})
+}
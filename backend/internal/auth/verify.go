@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"wingbox.spencrc/internal/problem"
+)
+
+// Headers the verification endpoint responds with, so nginx can forward them to the api service with auth_request_set
+const USER_ID_HEADER = "X-User-ID"
+const TOKEN_ID_HEADER = "X-Token-ID"
+
+// The same problem handlers respond with, so they can return what authenticate and sessionUser return as is
+var ErrNotLoggedIn error = problem.NotLoggedIn
+
+// Validates the access token cookie and checks it wasn't revoked by logging out.
+// On failure, returns empty strings and ErrNotLoggedIn, or the database's error, wrapped, if the revocation check itself failed.
+// On success, returns the user's ID (sub), the token's ID (jti) and nil.
+func (as *AuthService) authenticate(r *http.Request) (string, string, error) {
+	claims, err := as.accessMgr().GetClaimsOfValid(r)
+	if err != nil {
+		return "", "", ErrNotLoggedIn
+	}
+
+	// claims are set by us in Redirect, but make sure they're actually there before trusting them
+	sub, jti, ok := subAndJti(claims)
+	if !ok {
+		return "", "", ErrNotLoggedIn
+	}
+
+	revoked, err := isAccessTokenRevoked(r.Context(), as.server.Db, jti)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to check if access token was revoked: %w", err)
+	}
+	if revoked {
+		return "", "", ErrNotLoggedIn
+	}
+
+	return sub, jti, nil
+}
+
+// Answers nginx's auth_request subrequest. Validates the access token cookie and responds with 200 if it's valid, or 401 if not (or if it was revoked by logging out).
+// nginx only looks at the status, so the problem it's sent with on failure goes no further.
+// On success, the user's ID (sub) and the token's ID (jti) are sent back as response headers.
+func (as *AuthService) Verify(w http.ResponseWriter, r *http.Request) error {
+	sub, jti, err := as.authenticate(r)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set(USER_ID_HEADER, sub)
+	w.Header().Set(TOKEN_ID_HEADER, jti)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
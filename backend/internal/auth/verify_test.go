@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"wingbox.spencrc/internal/server"
+)
+
+const TEST_JWT_KEY = "0123456789abcdef0123456789abcdef"
+const TEST_JWT_SALT = "salt"
+
+func newTestAuthService(t *testing.T) *AuthService {
+	return newTestAuthServiceWithKeys(t, newHMACKeys([]byte(TEST_JWT_KEY), []byte(TEST_JWT_SALT)))
+}
+
+// The config's defaults, as env.Load would fill them in
+func newTestConfig() Config {
+	return Config{ReturnToPrefixes: []string{"/"}, AccessTokenTTL: 3 * time.Minute, RefreshTokenTTL: 720 * time.Hour}
+}
+
+func newTestAuthServiceWithKeys(t *testing.T, keys signingKeys) *AuthService {
+	cfg := newTestConfig()
+	managers, err := newTokenManagers(keys, cfg)
+	if err != nil {
+		t.Fatalf("could not create cookie managers: %v", err)
+	}
+	as := &AuthService{cfg: cfg, metrics: newAuthMetrics(prometheus.NewRegistry())}
+	as.tokenMgrs.Store(managers)
+	return as
+}
+
+// Issues an access token cookie with the given claims, then returns it so it can be attached to another request
+func issueAccessCookie(t *testing.T, as *AuthService, claims map[string]string) *http.Cookie {
+	rec := httptest.NewRecorder()
+	if err := as.accessMgr().SetJWTCookie(rec, httptest.NewRequest("GET", "/", nil), claims); err != nil {
+		t.Fatalf("could not set access cookie: %v", err)
+	}
+	return rec.Result().Cookies()[0]
+}
+
+func TestVerify(t *testing.T) {
+	as := newTestAuthServiceWithDB(t)
+	as.server.Db.Exec("INSERT INTO revoked_access_tokens (jti, expires_at) VALUES ('revoked-jti', ?)", time.Now().Add(time.Minute).Unix())
+	refreshToken := issueRefreshCookie(t, as, "refresh-jti", "1", time.Now().Add(time.Hour)).Value
+
+	var tests = []struct {
+		name           string
+		cookie         *http.Cookie
+		expectedStatus int
+		expectedSub    string
+		expectedJti    string
+	}{
+		{
+			name:           "valid token",
+			cookie:         issueAccessCookie(t, as, map[string]string{"sub": "42", "jti": "abc-123"}),
+			expectedStatus: http.StatusOK,
+			expectedSub:    "42",
+			expectedJti:    "abc-123",
+		},
+		{
+			name:           "revoked token",
+			cookie:         issueAccessCookie(t, as, map[string]string{"sub": "42", "jti": "revoked-jti"}),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "missing cookie",
+			cookie:         nil,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "tampered token",
+			cookie:         &http.Cookie{Name: ACCESS_COOKIE_NAME, Value: "not.a.token"},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			// its jti is never blocklisted, so it would keep passing after logging out
+			name:           "refresh token",
+			cookie:         &http.Cookie{Name: ACCESS_COOKIE_NAME, Value: refreshToken},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "missing jti",
+			cookie:         issueAccessCookie(t, as, map[string]string{"sub": "42"}),
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/verify", nil)
+			if test.cookie != nil {
+				req.AddCookie(test.cookie)
+			}
+			rec := httptest.NewRecorder()
+
+			server.HandlerFunc(as.Verify).ServeHTTP(rec, req)
+
+			if rec.Code != test.expectedStatus {
+				t.Errorf("expected status %d, got %d", test.expectedStatus, rec.Code)
+			}
+			if sub := rec.Header().Get(USER_ID_HEADER); sub != test.expectedSub {
+				t.Errorf("expected %s header %q, got %q", USER_ID_HEADER, test.expectedSub, sub)
+			}
+			if jti := rec.Header().Get(TOKEN_ID_HEADER); jti != test.expectedJti {
+				t.Errorf("expected %s header %q, got %q", TOKEN_ID_HEADER, test.expectedJti, jti)
+			}
+		})
+	}
+}
@@ -8,10 +8,11 @@ import (
 type Chain []func(http.Handler) http.Handler
 
 func (c Chain) ThenFunc(handler http.HandlerFunc) http.Handler {
-	return c.then(handler)
+	return c.Then(handler)
 }
 
-func (c Chain) then(handler http.Handler) http.Handler {
+// Like ThenFunc, for any other kind of handler, e.g. a server.HandlerFunc
+func (c Chain) Then(handler http.Handler) http.Handler {
 	for _, middleware := range slices.Backward(c) {
 		handler = middleware(handler)
 	}
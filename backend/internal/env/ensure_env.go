@@ -1,14 +0,0 @@
-package env
-
-import (
-	"fmt"
-	"os"
-)
-
-func Ensureenv(key string) string {
-	val := os.Getenv(key)
-	if val == "" {
-		panic(fmt.Sprintf("Environment variable %s failed to load", key))
-	}
-	return val
-}
\ No newline at end of file
@@ -0,0 +1,190 @@
+package env
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrMissing error = errors.New("is required but not set")
+var ErrInvalid error = errors.New("is invalid")
+var ErrUnsupportedType error = errors.New("unsupported config field type")
+var ErrWorldReadable error = errors.New("is readable by everyone, it should only be readable by the service's user")
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+// Config structs can implement this to check things tags can't, e.g. one variable being required only when another is set.
+// Load calls it on the struct it was given once every field is filled, and reports what it returns along with everything else.
+type Validator interface {
+	Validate() error
+}
+
+// Fills the struct cfg points to from the environment, going by each field's tags:
+//
+//	env:"NAME"         the variable to read. Fields without it are skipped, unless they're structs, which are filled the same way
+//	envPrefix:"FOO_"   on a struct field, put in front of the names of every variable inside it
+//	default:"value"    used if the variable is unset or empty
+//	required:"true"    the variable must be set, if there's no default
+//	oneof:"a|b"        the value must be one of these
+//
+// If a variable is unset or empty, but NAME_FILE is set, its value is read from that file instead, Docker secrets style, with trailing
+// newlines trimmed. Such files must not be readable by everyone. Secrets should go in Secret fields, so they're redacted when logged.
+//
+// Fields can be strings, Secrets, bools, ints, uints, time.Durations (e.g. "5s"), or []string (comma separated).
+// Rather than stopping at the first problem, every missing or invalid variable is reported at once, joined into the one error.
+func Load(cfg any) error {
+	return load(cfg, os.LookupEnv)
+}
+
+func load(cfg any, lookup func(string) (string, bool)) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("env.Load needs a pointer to a struct, got %T", cfg)
+	}
+
+	errs := loadStruct(v.Elem(), "", lookup)
+	if validator, ok := cfg.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func loadStruct(v reflect.Value, prefix string, lookup func(string) (string, bool)) []error {
+	var errs []error
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, ok := field.Tag.Lookup("env")
+		if !ok {
+			if field.Type.Kind() == reflect.Struct {
+				errs = append(errs, loadStruct(v.Field(i), prefix+field.Tag.Get("envPrefix"), lookup)...)
+			}
+			continue
+		}
+		name = prefix + name
+
+		raw, err := lookupValue(name, lookup)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if raw == "" {
+			raw, ok = field.Tag.Lookup("default")
+			if !ok {
+				if field.Tag.Get("required") == "true" {
+					errs = append(errs, fmt.Errorf("%s %w", name, ErrMissing))
+				}
+				continue
+			}
+		}
+
+		if oneof, ok := field.Tag.Lookup("oneof"); ok && !slices.Contains(strings.Split(oneof, "|"), raw) {
+			errs = append(errs, fmt.Errorf("%s %w: must be one of %s, got %q", name, ErrInvalid, strings.ReplaceAll(oneof, "|", ", "), raw))
+			continue
+		}
+		if err := setField(v.Field(i), raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s %w: %w", name, ErrInvalid, err))
+		}
+	}
+	return errs
+}
+
+// Reads the variable, or if it's unset or empty, the file NAME_FILE points to. Returns an empty string if neither is set.
+func lookupValue(name string, lookup func(string) (string, bool)) (string, error) {
+	if raw, _ := lookup(name); raw != "" {
+		return raw, nil
+	}
+
+	path, _ := lookup(name + "_FILE")
+	if path == "" {
+		return "", nil
+	}
+	raw, err := readSecretFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s_FILE %w: %w", name, ErrInvalid, err)
+	}
+	return raw, nil
+}
+
+// Reads the file, minus trailing newlines, refusing it if anyone could have read it too
+func readSecretFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().Perm()&0o004 != 0 {
+		return "", fmt.Errorf("%s %w", path, ErrWorldReadable)
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Parses raw into the field, going by its type
+func setField(field reflect.Value, raw string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("%w: %s", ErrUnsupportedType, field.Type())
+		}
+		var items []string
+		for item := range strings.SplitSeq(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items).Convert(field.Type()))
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedType, field.Type())
+	}
+	return nil
+}
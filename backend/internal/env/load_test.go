@@ -0,0 +1,183 @@
+package env
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testClient struct {
+	ID     string `env:"CLIENT_ID"`
+	Secret string `env:"CLIENT_SECRET"`
+}
+
+type testConfig struct {
+	Port     uint64        `env:"PORT" default:"3001"`
+	Timeout  time.Duration `env:"TIMEOUT" default:"5s"`
+	Debug    bool          `env:"DEBUG"`
+	URL      string        `env:"URL" required:"true"`
+	Alg      string        `env:"ALG" default:"HS256" oneof:"HS256|ES256"`
+	Prefixes []string      `env:"PREFIXES" default:"/"`
+	Discord  testClient    `envPrefix:"DISCORD_"`
+	ignored  string        `env:"IGNORED"`
+}
+
+func (c testConfig) Validate() error {
+	if c.Discord.ID != "" && c.Discord.Secret == "" {
+		return fmt.Errorf("DISCORD_CLIENT_SECRET %w when DISCORD_CLIENT_ID is set", ErrMissing)
+	}
+	return nil
+}
+
+func lookupFrom(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		val, ok := vars[key]
+		return val, ok
+	}
+}
+
+func TestLoad(t *testing.T) {
+	var cfg testConfig
+	err := load(&cfg, lookupFrom(map[string]string{
+		"URL":                   "https://wingbox.test",
+		"TIMEOUT":               "1m",
+		"DEBUG":                 "true",
+		"PREFIXES":              "/app, /settings,",
+		"DISCORD_CLIENT_ID":     "1234",
+		"DISCORD_CLIENT_SECRET": "shh",
+		"IGNORED":               "nope",
+	}))
+	if err != nil {
+		t.Fatalf("did not expect error, got %v", err)
+	}
+
+	want := testConfig{
+		Port:     3001,
+		Timeout:  time.Minute,
+		Debug:    true,
+		URL:      "https://wingbox.test",
+		Alg:      "HS256",
+		Prefixes: []string{"/app", "/settings"},
+		Discord:  testClient{ID: "1234", Secret: "shh"},
+	}
+	if cfg.Port != want.Port || cfg.Timeout != want.Timeout || cfg.Debug != want.Debug || cfg.URL != want.URL || cfg.Alg != want.Alg ||
+		!slices.Equal(cfg.Prefixes, want.Prefixes) || cfg.Discord != want.Discord || cfg.ignored != "" {
+		t.Errorf("expected %+v, got %+v", want, cfg)
+	}
+}
+
+// Every problem should be reported at once, not just the first
+func TestLoadReportsEveryProblem(t *testing.T) {
+	var cfg testConfig
+	err := load(&cfg, lookupFrom(map[string]string{
+		"PORT":              "-1",
+		"TIMEOUT":           "soon",
+		"ALG":               "none",
+		"DISCORD_CLIENT_ID": "1234",
+	}))
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if !errors.Is(err, ErrMissing) || !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected both missing and invalid errors, got %v", err)
+	}
+	for _, name := range []string{"PORT", "TIMEOUT", "URL", "ALG", "DISCORD_CLIENT_SECRET"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("expected %s to be reported, got %v", name, err)
+		}
+	}
+}
+
+func TestLoadRejectsNonStruct(t *testing.T) {
+	var cfg testConfig
+	if err := load(cfg, lookupFrom(nil)); err == nil {
+		t.Errorf("expected error when not given a pointer")
+	}
+	var unsupported struct {
+		Ratio float64 `env:"RATIO"`
+	}
+	if err := load(&unsupported, lookupFrom(map[string]string{"RATIO": "0.5"})); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected ErrUnsupportedType, got %v", err)
+	}
+}
+
+func writeSecretFile(t *testing.T, contents string, perm os.FileMode) string {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(contents), perm); err != nil {
+		t.Fatalf("could not write secret file: %v", err)
+	}
+	// WriteFile's permissions are masked by the umask
+	if err := os.Chmod(path, perm); err != nil {
+		t.Fatalf("could not chmod secret file: %v", err)
+	}
+	return path
+}
+
+func TestLoadFromFile(t *testing.T) {
+	type config struct {
+		Secret Secret `env:"SECRET" required:"true"`
+	}
+
+	tests := []struct {
+		name    string
+		vars    map[string]string
+		want    Secret
+		wantErr error
+	}{
+		{"file", map[string]string{"SECRET_FILE": writeSecretFile(t, "shh\r\n\n", 0o600)}, "shh", nil},
+		{"variable wins", map[string]string{"SECRET": "loud", "SECRET_FILE": writeSecretFile(t, "shh", 0o600)}, "loud", nil},
+		{"group readable", map[string]string{"SECRET_FILE": writeSecretFile(t, "shh", 0o640)}, "shh", nil},
+		{"world readable", map[string]string{"SECRET_FILE": writeSecretFile(t, "shh", 0o644)}, "", ErrWorldReadable},
+		{"missing file", map[string]string{"SECRET_FILE": filepath.Join(t.TempDir(), "nope")}, "", os.ErrNotExist},
+		{"empty file", map[string]string{"SECRET_FILE": writeSecretFile(t, "\n", 0o600)}, "", ErrMissing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config
+			err := load(&cfg, lookupFrom(tt.vars))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if cfg.Secret != tt.want {
+				t.Errorf("expected %q, got %q", tt.want.Reveal(), cfg.Secret.Reveal())
+			}
+		})
+	}
+}
+
+// Logging a whole config shouldn't give away what's in its secrets, however it's logged
+func TestSecretRedacted(t *testing.T) {
+	cfg := struct {
+		Name   string
+		Secret Secret
+		Empty  Secret
+	}{"wingbox", "hunter2", ""}
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	logger.Info("config", "config", cfg, "secret", cfg.Secret)
+	logger = slog.New(slog.NewTextHandler(&buf, nil))
+	logger.Info("config", "config", cfg, "secret", cfg.Secret)
+
+	outputs := []string{fmt.Sprint(cfg), fmt.Sprintf("%+v", cfg), fmt.Sprintf("%#v", cfg), buf.String()}
+	for _, out := range outputs {
+		if strings.Contains(out, "hunter2") {
+			t.Errorf("secret leaked: %s", out)
+		}
+		if !strings.Contains(out, REDACTED) {
+			t.Errorf("expected secret to show as redacted: %s", out)
+		}
+	}
+
+	if cfg.Secret.Reveal() != "hunter2" {
+		t.Errorf("expected Reveal to return the secret")
+	}
+}
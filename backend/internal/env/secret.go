@@ -0,0 +1,40 @@
+package env
+
+import (
+	"encoding/json"
+	"log/slog"
+)
+
+const REDACTED = "[REDACTED]"
+
+// A config value that must never end up in logs, e.g. a client secret. Printing, logging or marshalling it shows REDACTED instead, so
+// configs holding them are safe to log whole. Use Reveal to get at the actual value.
+type Secret string
+
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// Empty secrets print as empty, so it's still visible whether one is set
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return REDACTED
+}
+
+func (s Secret) GoString() string {
+	return `env.Secret("` + s.String() + `")`
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
@@ -0,0 +1,305 @@
+package jwks
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// A JSON Web Key (RFC 7517), holding just the fields needed for public keys
+type Key struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP (Ed25519)
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// A JSON Web Key Set, as served from a jwks_uri
+type Set struct {
+	Keys []Key `json:"keys"`
+}
+
+var ErrUnsupportedKey error = errors.New("unsupported key type")
+var ErrUnknownKid error = errors.New("no key with that kid")
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// Converts the JWK into the matching Go public key type: *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+func (k Key) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: EC curve %s", ErrUnsupportedKey, k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y coordinate: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on its curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("%w: OKP curve %s", ErrUnsupportedKey, k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKey, k.Kty)
+	}
+}
+
+// Derives the kid for a public key: base64url of the first 16 bytes of the SHA-256 of its PKIX encoding.
+// This is how go-jwt-cookie picks the kid header for tokens it signs, so keys published with it line up with those tokens.
+func KeyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:16]), nil
+}
+
+// Converts a Go public key into a signing JWK for alg (e.g. "ES256"), with its kid derived by KeyID. The reverse of Key.PublicKey.
+func FromPublicKey(pub crypto.PublicKey, alg string) (Key, error) {
+	kid, err := KeyID(pub)
+	if err != nil {
+		return Key{}, err
+	}
+	key := Key{Kid: kid, Use: "sig", Alg: alg}
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		key.Kty = "RSA"
+		key.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		key.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		key.Kty = "EC"
+		key.Crv = pub.Curve.Params().Name
+		// coordinates must be padded to the full size of the curve (RFC 7518 section 6.2.1.2)
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		key.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		key.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		key.Kty = "OKP"
+		key.Crv = "Ed25519"
+		key.X = base64.RawURLEncoding.EncodeToString(pub)
+	default:
+		return Key{}, fmt.Errorf("%w: %T", ErrUnsupportedKey, pub)
+	}
+
+	return key, nil
+}
+
+// Fetches and caches a remote JWKS, e.g. an identity provider's jwks_uri. Keys are refetched once the cache is older than maxAge, or when asked for
+// a kid it doesn't know (e.g. the provider rotated keys), but no more often than minRefresh so bogus kids can't be used to hammer the provider.
+// If a refetch fails, cached keys keep being used, and it's retried no more often than minRefresh either, so a provider that's briefly down
+// neither refuses every token nor gets a fetch for each of them.
+type Cache struct {
+	url        string
+	client     *http.Client
+	maxAge     time.Duration
+	minRefresh time.Duration
+
+	// held while fetching, so only one fetch runs at a time, without holding up requests that can make do with cached keys as mu would
+	fetching sync.Mutex
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// when a fetch was last tried, successful or not, and what went wrong if it wasn't
+	attemptedAt time.Time
+	err         error
+}
+
+func NewCache(url string, client *http.Client) *Cache {
+	return &Cache{
+		url:        url,
+		client:     client,
+		maxAge:     time.Hour,
+		minRefresh: 10 * time.Second,
+	}
+}
+
+// Downloads the key set. Keys of types we don't support are skipped rather than failing the whole set.
+func (c *Cache) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks endpoint returned unexpected status %d", res.StatusCode)
+	}
+
+	var set Set
+	if err = json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.PublicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	return keys, nil
+}
+
+// Whether the key set should be fetched again, given whether the kid being asked for is cached. Must be called with c.mu held!
+func (c *Cache) due(known bool) bool {
+	if time.Since(c.attemptedAt) < c.minRefresh {
+		return false
+	}
+	return c.keys == nil || !known || time.Since(c.fetchedAt) > c.maxAge
+}
+
+// Fetches the key set, replacing whatever was cached, unless a fetch was tried after since, as whoever held c.fetching before us has just
+// done it. If the fetch fails, the cached keys are kept. Must be called with c.fetching held, but not c.mu!
+func (c *Cache) refresh(ctx context.Context, since time.Time) {
+	c.mu.Lock()
+	tried := c.attemptedAt.After(since)
+	c.mu.Unlock()
+	if tried {
+		return
+	}
+
+	keys, err := c.fetch(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attemptedAt = time.Now()
+	c.err = err
+	if err == nil {
+		c.keys = keys
+		c.fetchedAt = c.attemptedAt
+	}
+}
+
+// Returns the public key with the given kid, fetching the key set if needed
+func (c *Cache) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	_, known := c.keys[kid]
+	due := c.due(known)
+	since := c.attemptedAt
+	c.mu.Unlock()
+
+	switch {
+	case due && known:
+		// the cached key is still good to verify with, so don't wait on a fetch that's already running
+		if c.fetching.TryLock() {
+			c.refresh(ctx, since)
+			c.fetching.Unlock()
+		}
+	case due:
+		c.fetching.Lock()
+		c.refresh(ctx, since)
+		c.fetching.Unlock()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, ok := c.keys[kid]
+	if !ok && c.err != nil {
+		return nil, fmt.Errorf("%w: %q, and could not fetch keys: %w", ErrUnknownKid, kid, c.err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKid, kid)
+	}
+	return key, nil
+}
+
+// Makes sure there are keys to verify with, e.g. as a readiness check. Only fetches if it hasn't been fetched yet or the cache is stale, so
+// calling it often doesn't hammer the provider once keys are cached. Keys that are cached but couldn't be refetched still count.
+func (c *Cache) Check(ctx context.Context) error {
+	c.mu.Lock()
+	due := c.due(true)
+	since := c.attemptedAt
+	c.mu.Unlock()
+
+	if due {
+		c.fetching.Lock()
+		c.refresh(ctx, since)
+		c.fetching.Unlock()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keys == nil {
+		// only nil once a fetch has been tried and failed
+		return c.err
+	}
+	return nil
+}
+
+// Returns a jwt.Keyfunc that picks the verification key by the token's kid header
+func (c *Cache) Keyfunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return c.Key(ctx, kid)
+	}
+}
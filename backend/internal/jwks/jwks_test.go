@@ -0,0 +1,148 @@
+package jwks
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFromPublicKeyRoundTrip(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256Key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p521Key, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	edKey, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	var tests = []struct {
+		name string
+		pub  crypto.PublicKey
+		alg  string
+	}{
+		{name: "RSA", pub: &rsaKey.PublicKey, alg: "RS256"},
+		{name: "P-256", pub: &p256Key.PublicKey, alg: "ES256"},
+		{name: "P-521", pub: &p521Key.PublicKey, alg: "ES512"},
+		{name: "Ed25519", pub: edKey, alg: "EdDSA"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := FromPublicKey(test.pub, test.alg)
+			if err != nil {
+				t.Fatalf("could not convert public key: %v", err)
+			}
+			if key.Kid == "" || key.Alg != test.alg || key.Use != "sig" {
+				t.Errorf("expected kid, alg %s and use sig, got %+v", test.alg, key)
+			}
+
+			pub, err := key.PublicKey()
+			if err != nil {
+				t.Fatalf("could not convert JWK back: %v", err)
+			}
+			if !pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(test.pub) {
+				t.Errorf("expected the same public key back")
+			}
+		})
+	}
+}
+
+func TestCacheKeepsKeysWhenRefetchFails(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk, _ := FromPublicKey(&key.PublicKey, "ES256")
+
+	var fetches atomic.Int32
+	var down atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(Set{Keys: []Key{jwk}})
+	}))
+	defer srv.Close()
+
+	cache := NewCache(srv.URL, srv.Client())
+	ctx := context.Background()
+	if _, err := cache.Key(ctx, jwk.Kid); err != nil {
+		t.Fatalf("could not get key: %v", err)
+	}
+
+	// the cache goes stale while the endpoint is down
+	down.Store(true)
+	cache.mu.Lock()
+	cache.fetchedAt = time.Now().Add(-2 * cache.maxAge)
+	cache.attemptedAt = cache.fetchedAt
+	cache.mu.Unlock()
+
+	for range 3 {
+		if _, err := cache.Key(ctx, jwk.Kid); err != nil {
+			t.Errorf("expected cached key to be used when refetching fails, got %v", err)
+		}
+	}
+	if _, err := cache.Key(ctx, "unknown"); !errors.Is(err, ErrUnknownKid) {
+		t.Errorf("expected ErrUnknownKid for a kid that isn't cached, got %v", err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("expected a failed refetch not to be retried within minRefresh, got %d fetches", n)
+	}
+	if err := cache.Check(ctx); err != nil {
+		t.Errorf("expected cached keys to still count as ready, got %v", err)
+	}
+}
+
+func TestCacheServesCachedKeysWhileFetching(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk, _ := FromPublicKey(&key.PublicKey, "ES256")
+
+	var hang atomic.Bool
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hang.Load() {
+			close(fetching)
+			<-release
+		}
+		json.NewEncoder(w).Encode(Set{Keys: []Key{jwk}})
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	cache := NewCache(srv.URL, srv.Client())
+	if _, err := cache.Key(context.Background(), jwk.Kid); err != nil {
+		t.Fatalf("could not get key: %v", err)
+	}
+
+	hang.Store(true)
+	cache.mu.Lock()
+	cache.fetchedAt = time.Now().Add(-2 * cache.maxAge)
+	cache.attemptedAt = cache.fetchedAt
+	cache.mu.Unlock()
+
+	go cache.Key(context.Background(), jwk.Kid)
+	<-fetching
+
+	// the refetch above is stuck, which mustn't hold anyone else up
+	done := make(chan error, 1)
+	go func() {
+		_, err := cache.Key(context.Background(), jwk.Kid)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected cached key, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected cached key to be served while another request is fetching")
+	}
+}
@@ -2,16 +2,96 @@ package middleware
 
 import (
 	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
-func LogRequest(logger *slog.Logger) func(http.Handler) http.Handler {
+// How LogRequest decides what to log
+type LogOptions struct {
+	// Log only 1 in every SampleSuccess successful (below 400) requests. 0 and 1 log all of them. Failed requests are always logged
+	SampleSuccess uint64
+	// Paths never logged, e.g. one an uptime monitor polls
+	SkipPaths []string
+	// Proxies believed when they say who the client is in X-Forwarded-For, i.e. nginx. Requests from anywhere else are logged as
+	// coming from whoever connected
+	TrustedProxies []netip.Prefix
+}
+
+// Logs each request once the handler has finished with it, with its status, how long it took, how big the response was, its request ID
+// and the client's IP. Server errors are logged as errors and client errors as warnings. Must come after RequestID, or there's no ID.
+func LogRequest(logger *slog.Logger, opts LogOptions) func(http.Handler) http.Handler {
+	var successes atomic.Uint64
+
 	// we need to return a func like this so it can be chained nicely
 	//  effectively, pass logger function -> return handler function -> do the middleware!
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			logger.Info("request received", "method", r.Method, "path", r.URL.Path)
-			next.ServeHTTP(w, r)
+			if slices.Contains(opts.SkipPaths, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			rec := newResponseRecorder(w)
+			next.ServeHTTP(rec, r)
+
+			status := rec.Status()
+			level := slog.LevelInfo
+			switch {
+			case status >= 500:
+				level = slog.LevelError
+			case status >= 400:
+				level = slog.LevelWarn
+			case opts.SampleSuccess > 1 && (successes.Add(1)-1)%opts.SampleSuccess != 0:
+				return
+			}
+
+			logger.LogAttrs(r.Context(), level, "request handled",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Duration("duration", time.Since(start)),
+				slog.Int("bytes", rec.bytes),
+				slog.String("request_id", RequestIDFrom(r.Context())),
+				slog.String("client_ip", clientIP(r, opts.TrustedProxies)),
+			)
 		})
 	}
-}
\ No newline at end of file
+}
+
+// The IP of whoever made the request. If it came through trusted proxies, that's the last address in X-Forwarded-For they didn't add
+// themselves, as anything before it could have been made up by the client.
+func clientIP(r *http.Request, trusted []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !isTrusted(addr, trusted) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// whatever's left was written by someone we don't trust
+			break
+		}
+		host = hop.String()
+		if !isTrusted(hop, trusted) {
+			break
+		}
+	}
+	return host
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	addr = addr.Unmap()
+	return slices.ContainsFunc(trusted, func(p netip.Prefix) bool { return p.Contains(addr) })
+}
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+type logEntry struct {
+	Level     string `json:"level"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Status    int    `json:"status"`
+	Bytes     int    `json:"bytes"`
+	RequestID string `json:"request_id"`
+	ClientIP  string `json:"client_ip"`
+}
+
+func decodeLogs(t *testing.T, buf *bytes.Buffer) []logEntry {
+	var entries []logEntry
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var entry logEntry
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatalf("could not decode log entry: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLogRequest(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	logRequest := LogRequest(logger, LogOptions{SampleSuccess: 2, SkipPaths: []string{"/healthz"}})
+
+	handler := RequestID(logger)(logRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte("hello"))
+		}
+	})))
+
+	for _, path := range []string{"/ok", "/ok", "/ok", "/missing", "/broken", "/healthz"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set(REQUEST_ID_HEADER, "abc123")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// every other success is sampled out, failures never are, and health checks aren't logged at all
+	want := []logEntry{
+		{"INFO", "GET", "/ok", 200, 5, "abc123", "192.0.2.1"},
+		{"INFO", "GET", "/ok", 200, 5, "abc123", "192.0.2.1"},
+		{"WARN", "GET", "/missing", 404, 19, "abc123", "192.0.2.1"},
+		{"ERROR", "GET", "/broken", 500, 0, "abc123", "192.0.2.1"},
+	}
+	got := decodeLogs(t, &buf)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d log entries, got %d: %+v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("172.16.0.0/12")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"untrusted proxy is ignored", "203.0.113.7:1234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"through nginx", "172.18.0.5:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		// the client can put whatever it likes in front of what nginx adds
+		{"spoofed", "172.18.0.5:1234", []string{"10.0.0.1, 198.51.100.1"}, "198.51.100.1"},
+		{"several headers", "172.18.0.5:1234", []string{"10.0.0.1", "198.51.100.1, 172.18.0.9"}, "198.51.100.1"},
+		{"garbage", "172.18.0.5:1234", []string{"nonsense, 198.51.100.1"}, "198.51.100.1"},
+		{"only proxies", "172.18.0.5:1234", []string{"172.18.0.9"}, "172.18.0.9"},
+		{"no header", "172.18.0.5:1234", nil, "172.18.0.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(req, trusted); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+// Wrapping the ResponseWriter shouldn't stop handlers streaming responses
+func TestResponseRecorderFlushes(t *testing.T) {
+	w := httptest.NewRecorder()
+	rec := newResponseRecorder(w)
+
+	if err := http.NewResponseController(rec).Flush(); err != nil {
+		t.Fatalf("expected flush to be passed on, got %v", err)
+	}
+	if !w.Flushed || rec.Status() != http.StatusOK {
+		t.Errorf("expected underlying writer to be flushed with a 200, got flushed %v status %d", w.Flushed, rec.Status())
+	}
+	if _, _, err := http.NewResponseController(rec).Hijack(); err == nil {
+		t.Errorf("expected hijacking a recorder that can't be hijacked to fail")
+	}
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Methods that get their own label value. Anything else is counted as OTHER, so junk methods can't blow up the number of series.
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true, http.MethodPatch: true,
+	http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// The route the request matched, e.g. "/identities/{provider}/{subject}", rather than its path, so each route is one series no matter
+// how many different paths it serves. The method is left off patterns that have one, as it's a label of its own.
+func routeLabel(r *http.Request) string {
+	if r.Pattern == "" {
+		return "unmatched"
+	}
+	if _, path, ok := strings.Cut(r.Pattern, " "); ok {
+		return path
+	}
+	return r.Pattern
+}
+
+func methodLabel(r *http.Request) string {
+	if knownMethods[r.Method] {
+		return r.Method
+	}
+	return "OTHER"
+}
+
+// Records how many requests each route gets, how long they take and how big the responses are, labelled by method, route and status,
+// registering the metrics with reg. Must wrap handlers after the ServeMux has routed to them (as BaseChain does), or the route is unknown.
+func Metrics(reg prometheus.Registerer) func(http.Handler) http.Handler {
+	labels := []string{"method", "route", "status"}
+	requests := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wingbox",
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Requests handled, by method, route and status.",
+	}, labels)
+	duration := promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "wingbox",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "How long requests took to handle, by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, labels)
+	size := promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "wingbox",
+		Subsystem: "http",
+		Name:      "response_size_bytes",
+		Help:      "Size of response bodies, by method, route and status.",
+		// 100B to 100MB
+		Buckets: prometheus.ExponentialBuckets(100, 10, 7),
+	}, labels)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := newResponseRecorder(w)
+			next.ServeHTTP(rec, r)
+
+			values := []string{methodLabel(r), routeLabel(r), strconv.Itoa(rec.Status())}
+			requests.WithLabelValues(values...).Inc()
+			duration.WithLabelValues(values...).Observe(time.Since(start).Seconds())
+			size.WithLabelValues(values...).Observe(float64(rec.bytes))
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics := Metrics(reg)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /identities/{provider}", metrics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})))
+	mux.Handle("/missing", metrics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})))
+
+	for _, path := range []string{"/identities/discord", "/identities/github", "/missing"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/missing", nil))
+
+	// both paths should be counted under the one route
+	want := `
+# HELP wingbox_http_requests_total Requests handled, by method, route and status.
+# TYPE wingbox_http_requests_total counter
+wingbox_http_requests_total{method="GET",route="/identities/{provider}",status="200"} 2
+wingbox_http_requests_total{method="GET",route="/missing",status="404"} 1
+wingbox_http_requests_total{method="OTHER",route="/missing",status="404"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "wingbox_http_requests_total"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(reg, "wingbox_http_request_duration_seconds", "wingbox_http_response_size_bytes"); n != 6 {
+		t.Errorf("expected a duration and size series per label set, got %d", n)
+	}
+}
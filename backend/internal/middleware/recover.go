@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"wingbox.spencrc/internal/problem"
+)
+
+// Turns a panicking handler into a problem.Internal carrying the request's ID, rather than net/http killing the connection and printing the stack
+// trace straight to stderr. The panic and its stack are logged through the request's logger instead, and counted, by route, in reg.
+// Panics with http.ErrAbortHandler are passed on, as that's how handlers ask net/http to abort the response.
+// Should come after LogRequest and Metrics (as in BaseChain), so they see the 500 too.
+func Recover(logger *slog.Logger, reg prometheus.Registerer) func(http.Handler) http.Handler {
+	panics := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wingbox",
+		Subsystem: "http",
+		Name:      "panics_total",
+		Help:      "Handlers that panicked, by route.",
+	}, []string{"route"})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := newResponseRecorder(w)
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+
+				panics.WithLabelValues(routeLabel(r)).Inc()
+				requestLogger, ok := LoggerFrom(r.Context())
+				if !ok {
+					requestLogger = logger
+				}
+				requestLogger.LogAttrs(r.Context(), slog.LevelError, "handler panicked",
+					slog.String("panic", fmt.Sprint(v)),
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.Any("stack", stack()),
+				)
+
+				// too late to change the response if the handler already started it, so net/http is left to cut it short
+				if rec.status != 0 {
+					panic(http.ErrAbortHandler)
+				}
+				problem.Write(rec, r, problem.Internal)
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// The stack of whoever called panic, one "function (file:line)" per frame, innermost first
+func stack() []string {
+	pcs := make([]uintptr, 64)
+	// skips runtime.Callers, stack, the deferred func and runtime.gopanic
+	n := runtime.Callers(4, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var lines []string
+	for {
+		frame, more := frames.Next()
+		lines = append(lines, fmt.Sprintf("%s (%s:%d)", frame.Function, frame.File, frame.Line))
+		if !more {
+			return lines
+		}
+	}
+}
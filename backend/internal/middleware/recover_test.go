@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func panicky(w http.ResponseWriter, r *http.Request) {
+	panic("oh no")
+}
+
+func TestRecover(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	reg := prometheus.NewRegistry()
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /boom", RequestID(logger)(Recover(logger, reg)(http.HandlerFunc(panicky))))
+
+	req := httptest.NewRequest("GET", "/boom", nil)
+	req.Header.Set(REQUEST_ID_HEADER, "abc123")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "abc123") {
+		t.Errorf("expected a 500 with the request ID, got %d %q", rec.Code, rec.Body.String())
+	}
+	want := `
+# HELP wingbox_http_panics_total Handlers that panicked, by route.
+# TYPE wingbox_http_panics_total counter
+wingbox_http_panics_total{route="/boom"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "wingbox_http_panics_total"); err != nil {
+		t.Error(err)
+	}
+
+	var entry struct {
+		Level     string   `json:"level"`
+		Panic     string   `json:"panic"`
+		RequestID string   `json:"request_id"`
+		Stack     []string `json:"stack"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("could not decode log entry: %v", err)
+	}
+	if entry.Level != "ERROR" || entry.Panic != "oh no" || entry.RequestID != "abc123" {
+		t.Errorf("expected the panic to be logged as an error with the request ID, got %+v", entry)
+	}
+	if len(entry.Stack) == 0 || !strings.Contains(entry.Stack[0], "middleware.panicky") {
+		t.Errorf("expected the stack to start where it panicked, got %v", entry.Stack)
+	}
+}
+
+// net/http expects ErrAbortHandler to reach it, so it can abort the response quietly
+func TestRecoverPassesOnAbort(t *testing.T) {
+	handler := Recover(slog.New(slog.DiscardHandler), prometheus.NewRegistry())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("expected ErrAbortHandler to be passed on, got %v", v)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+)
+
+// Header the request ID comes in and goes back out in. nginx sets it to its $request_id, so a request can be found in every service's logs
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// What request IDs from callers must look like to be kept, so they can't stuff anything strange into the logs. nginx's are 32 hex digits
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+type requestIDKey struct{}
+type loggerKey struct{}
+
+// Gets the ID RequestID gave the request. Returns an empty string if there isn't one, i.e. the route isn't behind RequestID.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Gets the logger RequestID put into the request's context, which tags everything it logs with the request's ID.
+// Returns false if there isn't one, i.e. the route isn't behind RequestID.
+func LoggerFrom(ctx context.Context) (*slog.Logger, bool) {
+	logger, ok := ctx.Value(loggerKey{}).(*slog.Logger)
+	return logger, ok
+}
+
+// Gives each request an ID, keeping the one in its X-Request-ID header if it has one, or making one up like nginx's if not, and sends it
+// back in the response's. Puts the ID, and a logger that tags everything it logs with it, into the request's context.
+func RequestID(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(REQUEST_ID_HEADER)
+			if !validRequestID.MatchString(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(REQUEST_ID_HEADER, id)
+
+			ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+			ctx = context.WithValue(ctx, loggerKey{}, logger.With("request_id", id))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
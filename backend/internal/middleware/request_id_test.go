@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	var gotID string
+	handler := RequestID(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = RequestIDFrom(r.Context())
+		logger, ok := LoggerFrom(r.Context())
+		if !ok {
+			t.Fatalf("expected a logger in the request's context")
+		}
+		logger.Info("hello")
+	}))
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"from nginx", "4bf92f3577b34da6a3ce929d0e0e4736", true},
+		{"missing", "", false},
+		{"too strange to keep", "abc\ninjected=true", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(REQUEST_ID_HEADER, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if tt.keep && gotID != tt.incoming {
+				t.Errorf("expected incoming ID %q to be kept, got %q", tt.incoming, gotID)
+			}
+			if !tt.keep && (gotID == tt.incoming || !validRequestID.MatchString(gotID)) {
+				t.Errorf("expected a new ID, got %q", gotID)
+			}
+			if res := rec.Header().Get(REQUEST_ID_HEADER); res != gotID {
+				t.Errorf("expected ID %q in response, got %q", gotID, res)
+			}
+
+			var entry struct {
+				RequestID string `json:"request_id"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("could not decode log entry: %v", err)
+			}
+			if entry.RequestID != gotID {
+				t.Errorf("expected handler's logs to be tagged with %q, got %q", gotID, entry.RequestID)
+			}
+		})
+	}
+
+	if _, ok := LoggerFrom(httptest.NewRequest("GET", "/", nil).Context()); ok {
+		t.Errorf("did not expect a logger outside RequestID")
+	}
+}
@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"wingbox.spencrc/internal/jwks"
+	"wingbox.spencrc/internal/problem"
+)
+
+// Name of the cookie the auth service sets access tokens in
+const ACCESS_COOKIE_NAME = "__Http-DO_NOT_SHARE-access_token"
+
+// Audience the auth service issues access tokens for. Its other tokens, e.g. refresh tokens, have their own, so they're refused here
+const ACCESS_TOKEN_AUDIENCE = "wingbox"
+
+var ErrMissingToken error = errors.New("no access token in cookie or Authorization header")
+var ErrInvalidClaims error = errors.New("access token is missing its sub or jti")
+
+// Who made the request, according to their access token
+type Principal struct {
+	UserID string
+	// ID of the access token itself
+	Jti    string
+	Scopes []string
+}
+
+type principalKey struct{}
+
+// Gets the Principal RequireAuth put into the request's context. Returns false if there isn't one, i.e. the route isn't behind RequireAuth.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// Checks access tokens issued by the auth service
+type TokenVerifier struct {
+	parser  *jwt.Parser
+	keyfunc func(ctx context.Context) jwt.Keyfunc
+	// nil if there's nothing to check, i.e. the keys are already in hand
+	check func(ctx context.Context) error
+}
+
+func newParser(methods ...string) *jwt.Parser {
+	return jwt.NewParser(
+		jwt.WithValidMethods(methods),
+		jwt.WithIssuer("auth"),
+		jwt.WithAudience(ACCESS_TOKEN_AUDIENCE),
+		jwt.WithExpirationRequired(),
+	)
+}
+
+// Verifies tokens signed with ES256 against the auth service's published keys, e.g. at http://auth:3002/.well-known/jwks.json.
+// Preferred, as this service never holds anything that could sign tokens.
+func NewJWKSVerifier(url string, client *http.Client) *TokenVerifier {
+	cache := jwks.NewCache(url, client)
+	return &TokenVerifier{parser: newParser("ES256"), keyfunc: cache.Keyfunc, check: cache.Check}
+}
+
+// Verifies tokens signed with HS256 by any of the secrets
+func NewHMACVerifier(secrets ...[]byte) *TokenVerifier {
+	return NewHMACVerifierFunc(func() [][]byte { return secrets })
+}
+
+// Like NewHMACVerifier, but asks secrets which to accept for every token, so they can change while running, e.g. as the auth service's
+// keyring is rotated
+func NewHMACVerifierFunc(secrets func() [][]byte) *TokenVerifier {
+	return &TokenVerifier{
+		parser: newParser("HS256"),
+		keyfunc: func(ctx context.Context) jwt.Keyfunc {
+			return func(token *jwt.Token) (any, error) {
+				keys := jwt.VerificationKeySet{}
+				for _, secret := range secrets() {
+					keys.Keys = append(keys.Keys, secret)
+				}
+				return keys, nil
+			}
+		},
+	}
+}
+
+// Makes sure the verifier has keys to verify with, i.e. that the JWKS can be fetched. For use as a readiness check.
+func (v *TokenVerifier) Ready(ctx context.Context) error {
+	if v.check == nil {
+		return nil
+	}
+	return v.check(ctx)
+}
+
+// Validates the access token and reads its claims into a Principal.
+// On failure, returns empty Principal and error.
+func (v *TokenVerifier) Verify(ctx context.Context, token string) (Principal, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.keyfunc(ctx)); err != nil {
+		return Principal{}, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	jti, _ := claims["jti"].(string)
+	if sub == "" || jti == "" {
+		return Principal{}, ErrInvalidClaims
+	}
+
+	// space separated, per RFC 8693 section 4.2
+	scope, _ := claims["scope"].(string)
+	return Principal{UserID: sub, Jti: jti, Scopes: strings.Fields(scope)}, nil
+}
+
+// Pulls the access token from the Authorization header if it has a Bearer token, otherwise from the access cookie
+func tokenFromRequest(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || token == "" {
+			return "", ErrMissingToken
+		}
+		return token, nil
+	}
+
+	cookie, err := r.Cookie(ACCESS_COOKIE_NAME)
+	if err != nil || cookie.Value == "" {
+		return "", ErrMissingToken
+	}
+	return cookie.Value, nil
+}
+
+// Responds with 401 unless the request carries a valid access token, in the access cookie or as a Bearer token. Otherwise, the caller is put
+// into the request's context as a Principal (see PrincipalFrom), so handlers don't need to trust headers nginx would otherwise have set.
+// Revocation isn't checked here, so a token from a session that logged out keeps working until it expires (at most ACCESS_TOKEN_TTL) for
+// requests that skip nginx.
+func RequireAuth(verifier *TokenVerifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := tokenFromRequest(r)
+			if err == nil {
+				var principal Principal
+				if principal, err = verifier.Verify(r.Context(), token); err == nil {
+					next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+					return
+				}
+			}
+
+			w.Header().Set("WWW-Authenticate", `Bearer realm="wingbox"`)
+			problem.Write(w, r, problem.NotLoggedIn)
+		})
+	}
+}
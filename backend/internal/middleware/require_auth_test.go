@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"wingbox.spencrc/internal/jwks"
+)
+
+var TEST_SECRET = []byte("test-secret")
+
+func accessClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": "1",
+		"jti": "abc-123",
+		"iss": "auth",
+		"aud": "wingbox",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+func signHMAC(t *testing.T, secret []byte, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		t.Fatalf("could not sign token: %v", err)
+	}
+	return token
+}
+
+// Runs the request through RequireAuth, returning the response and the Principal the handler saw, if it was reached at all
+func serve(verifier *TokenVerifier, req *http.Request) (*httptest.ResponseRecorder, *Principal) {
+	var seen *Principal
+	handler := RequireAuth(verifier)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFrom(r.Context())
+		if ok {
+			seen = &principal
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec, seen
+}
+
+func TestRequireAuth(t *testing.T) {
+	verifier := NewHMACVerifier([]byte("old-secret"), TEST_SECRET)
+
+	expired := accessClaims()
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	wrongAudience := accessClaims()
+	wrongAudience["aud"] = "someone-else"
+	// signed with the same keys as access tokens, but it would keep working for its whole lifetime, logged out or not
+	refreshToken := accessClaims()
+	refreshToken["aud"] = "wingbox-refresh"
+	refreshToken["exp"] = time.Now().Add(720 * time.Hour).Unix()
+	noJti := accessClaims()
+	delete(noJti, "jti")
+	withScope := accessClaims()
+	withScope["scope"] = "read write"
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, accessClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name       string
+		cookie     string
+		header     string
+		wantStatus int
+		wantScopes []string
+	}{
+		{"cookie", signHMAC(t, TEST_SECRET, accessClaims()), "", http.StatusOK, nil},
+		{"bearer token", "", "Bearer " + signHMAC(t, TEST_SECRET, accessClaims()), http.StatusOK, nil},
+		{"bearer token wins over cookie", "garbage", "Bearer " + signHMAC(t, TEST_SECRET, accessClaims()), http.StatusOK, nil},
+		{"scopes", signHMAC(t, TEST_SECRET, withScope), "", http.StatusOK, []string{"read", "write"}},
+		{"missing", "", "", http.StatusUnauthorized, nil},
+		{"not bearer", "", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, nil},
+		{"wrong key", signHMAC(t, []byte("wrong-secret"), accessClaims()), "", http.StatusUnauthorized, nil},
+		{"expired", signHMAC(t, TEST_SECRET, expired), "", http.StatusUnauthorized, nil},
+		{"wrong audience", signHMAC(t, TEST_SECRET, wrongAudience), "", http.StatusUnauthorized, nil},
+		{"refresh token as bearer", "", "Bearer " + signHMAC(t, TEST_SECRET, refreshToken), http.StatusUnauthorized, nil},
+		{"no jti", signHMAC(t, TEST_SECRET, noJti), "", http.StatusUnauthorized, nil},
+		{"alg none", unsigned, "", http.StatusUnauthorized, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: ACCESS_COOKIE_NAME, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+
+			rec, principal := serve(verifier, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if principal != nil {
+					t.Fatalf("handler should not have been reached, got %+v", principal)
+				}
+				if rec.Header().Get("WWW-Authenticate") == "" {
+					t.Errorf("expected WWW-Authenticate header on 401")
+				}
+				return
+			}
+
+			if principal == nil {
+				t.Fatalf("expected principal in request context")
+			}
+			if principal.UserID != "1" || principal.Jti != "abc-123" {
+				t.Errorf("unexpected principal %+v", principal)
+			}
+			if !slices.Equal(principal.Scopes, tt.wantScopes) {
+				t.Errorf("expected scopes %v, got %v", tt.wantScopes, principal.Scopes)
+			}
+		})
+	}
+}
+
+func TestJWKSVerifier(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	published, err := jwks.FromPublicKey(&key.PublicKey, "ES256")
+	if err != nil {
+		t.Fatalf("could not convert key: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwks.Set{Keys: []jwks.Key{published}})
+	}))
+	defer srv.Close()
+
+	verifier := NewJWKSVerifier(srv.URL, srv.Client())
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, accessClaims())
+	token.Header["kid"] = published.Kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("could not sign token: %v", err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+signed)
+	if rec, principal := serve(verifier, req); rec.Code != http.StatusOK || principal == nil || principal.UserID != "1" {
+		t.Fatalf("expected ES256 token to verify against JWKS, got status %d", rec.Code)
+	}
+
+	// HS256 tokens must not get through just because the verifier was handed a public key
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+signHMAC(t, TEST_SECRET, accessClaims()))
+	if rec, _ := serve(verifier, req); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected HS256 token to be refused, got status %d", rec.Code)
+	}
+}
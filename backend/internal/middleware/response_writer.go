@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// Wraps a ResponseWriter to remember the status code and how many bytes of body were written, for middleware that reports on responses
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w}
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += n
+	return n, err
+}
+
+// The status the handler responded with. Handlers that never write anything get 200, same as net/http sends them.
+func (rr *responseRecorder) Status() int {
+	if rr.status == 0 {
+		return http.StatusOK
+	}
+	return rr.status
+}
+
+// Passes flushes on, so streaming responses still stream. Flushing sends the headers, so counts as a 200 if nothing was written yet.
+func (rr *responseRecorder) Flush() {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	http.NewResponseController(rr.ResponseWriter).Flush()
+}
+
+// Passes hijacks on, e.g. for websockets. Returns http.ErrNotSupported if the underlying ResponseWriter can't be hijacked.
+func (rr *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(rr.ResponseWriter).Hijack()
+	if err == nil && rr.status == 0 {
+		// the handler takes over the connection, so as far as net/http is concerned it switched protocols
+		rr.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Lets http.ResponseController reach the underlying ResponseWriter, e.g. to flush it
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
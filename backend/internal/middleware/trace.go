@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Starts a server span for each request, carrying on the trace from its traceparent header if it has one (nginx passes them along), so
+// spans for whatever the handler does, e.g. DB queries or outbound requests, end up in the same trace as the caller's.
+// Spans are named after the route like the metrics are, so like Metrics this must wrap handlers after the ServeMux has routed to them.
+func Trace() func(http.Handler) http.Handler {
+	name := otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return methodLabel(r) + " " + routeLabel(r)
+	})
+	return func(next http.Handler) http.Handler {
+		return otelhttp.NewHandler(next, "", name)
+	}
+}
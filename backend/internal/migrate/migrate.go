@@ -0,0 +1,246 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// The schema's migrations, numbered in the order they're applied. Each comes as a pair: NNNN_name.up.sql applies it, and NNNN_name.down.sql undoes
+// it. Applied migrations must never be edited, add a new one instead!
+//
+//go:embed migrations/*.sql
+var files embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// Checksum of the up SQL, recorded in the ledger so edits to applied migrations can be caught
+	Checksum string
+}
+
+// A row of the schema_migrations ledger
+type AppliedMigration struct {
+	Version   int
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+var ErrChecksumMismatch error = errors.New("applied migration has been edited since it was applied")
+var ErrUnknownMigration error = errors.New("database has a migration applied that doesn't exist")
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Parses every NNNN_name.up.sql and NNNN_name.down.sql pair in the directory of fsys into a Migration, sorted by version.
+// On failure (bad file name, duplicate version, missing half of a pair), returns nil and error.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	hasUp := map[int]bool{}
+	hasDown := map[int]bool{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration file %s should be named like 0001_name.up.sql or 0001_name.down.sql", entry.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s has an invalid version: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", m.Name, match[2])
+		}
+
+		contents, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		if match[3] == "up" {
+			sum := sha256.Sum256(contents)
+			m.Up = string(contents)
+			m.Checksum = hex.EncodeToString(sum[:])
+			hasUp[version] = true
+		} else {
+			m.Down = string(contents)
+			hasDown[version] = true
+		}
+	}
+
+	var migrations []Migration
+	for version, m := range byVersion {
+		if !hasUp[version] || !hasDown[version] {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	return migrations, nil
+}
+
+// Returns the migrations embedded in this package
+func Migrations() ([]Migration, error) {
+	return Load(files, "migrations")
+}
+
+// Creates the schema_migrations ledger if it doesn't exist yet
+func ensureLedger(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at INTEGER NOT NULL
+		);
+	`)
+	return err
+}
+
+// Reads the schema_migrations ledger, sorted by version
+func Applied(ctx context.Context, db *sql.DB) ([]AppliedMigration, error) {
+	if err := ensureLedger(ctx, db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applied []AppliedMigration
+	for rows.Next() {
+		var a AppliedMigration
+		var appliedAt int64
+		if err = rows.Scan(&a.Version, &a.Name, &a.Checksum, &appliedAt); err != nil {
+			return nil, err
+		}
+		a.AppliedAt = time.Unix(appliedAt, 0)
+		applied = append(applied, a)
+	}
+	return applied, rows.Err()
+}
+
+// Returns the version of the newest applied migration, or 0 if nothing has been applied. Unlike Applied, it never creates the ledger, so it's
+// safe for services that only read the database to call, e.g. to check the migrator has already run.
+func SchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var ledgers int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").Scan(&ledgers)
+	if err != nil || ledgers == 0 {
+		return 0, err
+	}
+
+	var version int
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+// Makes sure every applied migration still exists and hasn't been edited since. Running on top of a schema that doesn't match its files would only
+// make things worse, so the migrator refuses to do anything if this fails.
+func Verify(applied []AppliedMigration, migrations []Migration) error {
+	byVersion := map[int]Migration{}
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	for _, a := range applied {
+		m, ok := byVersion[a.Version]
+		if !ok {
+			return fmt.Errorf("%w: version %d (%s)", ErrUnknownMigration, a.Version, a.Name)
+		}
+		if m.Checksum != a.Checksum {
+			return fmt.Errorf("%w: version %d (%s)", ErrChecksumMismatch, a.Version, a.Name)
+		}
+	}
+	return nil
+}
+
+// Runs the step's SQL and updates the ledger to match, all in one transaction. If anything fails, neither happens.
+func run(ctx context.Context, db *sql.DB, step Step) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, step.SQL()); err != nil {
+		return err
+	}
+
+	m := step.Migration
+	if step.Direction == DirectionUp {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO schema_migrations (version, name, checksum, applied_at)
+			VALUES (?, ?, ?, ?);
+		`, m.Version, m.Name, m.Checksum, time.Now().Unix())
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Runs each step of the plan in order, each in its own transaction. Stops at the first step that fails.
+// On failure, returns the steps that completed before it and error.
+// On success, returns every step and nil.
+func Run(ctx context.Context, db *sql.DB, plan []Step) ([]Step, error) {
+	if err := ensureLedger(ctx, db); err != nil {
+		return nil, err
+	}
+
+	for i, step := range plan {
+		if err := run(ctx, db, step); err != nil {
+			return plan[:i], fmt.Errorf("failed to %s: %w", step, err)
+		}
+	}
+	return plan, nil
+}
+
+// Applies every migration that hasn't been applied yet, in order. Refuses to apply anything if Verify fails.
+// On success, returns the migrations that were applied (if any) and nil.
+func Up(ctx context.Context, db *sql.DB, migrations []Migration) ([]Migration, error) {
+	applied, err := Applied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if err = Verify(applied, migrations); err != nil {
+		return nil, err
+	}
+
+	done, err := Run(ctx, db, PlanUp(applied, migrations))
+	var ran []Migration
+	for _, step := range done {
+		ran = append(ran, step.Migration)
+	}
+	return ran, err
+}
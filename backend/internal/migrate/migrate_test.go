@@ -0,0 +1,225 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", "file::memory:")
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	// every connection to :memory: is its own database, so make sure we only ever have one
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func mustLoad(t *testing.T, fsys fstest.MapFS) []Migration {
+	migrations, err := Load(fsys, "migrations")
+	if err != nil {
+		t.Fatalf("could not load migrations: %v", err)
+	}
+	return migrations
+}
+
+func tableExists(t *testing.T, db *sql.DB, table string) bool {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count); err != nil {
+		t.Fatalf("could not check for table %s: %v", table, err)
+	}
+	return count > 0
+}
+
+func TestLoad(t *testing.T) {
+	var tests = []struct {
+		name        string
+		files       fstest.MapFS
+		expectError bool
+		expected    []int
+	}{
+		{
+			name: "sorts by version",
+			files: fstest.MapFS{
+				"migrations/0010_c.up.sql":   {Data: []byte("SELECT 1;")},
+				"migrations/0010_c.down.sql": {Data: []byte("SELECT 1;")},
+				"migrations/0002_b.up.sql":   {Data: []byte("SELECT 1;")},
+				"migrations/0002_b.down.sql": {Data: []byte("SELECT 1;")},
+				"migrations/0001_a.up.sql":   {Data: []byte("SELECT 1;")},
+				"migrations/0001_a.down.sql": {Data: []byte("SELECT 1;")},
+			},
+			expected: []int{1, 2, 10},
+		},
+		{
+			name: "duplicate version",
+			files: fstest.MapFS{
+				"migrations/0001_a.up.sql":   {Data: []byte("SELECT 1;")},
+				"migrations/0001_a.down.sql": {Data: []byte("SELECT 1;")},
+				"migrations/1_b.up.sql":      {Data: []byte("SELECT 1;")},
+				"migrations/1_b.down.sql":    {Data: []byte("SELECT 1;")},
+			},
+			expectError: true,
+		},
+		{
+			name: "missing down file",
+			files: fstest.MapFS{
+				"migrations/0001_a.up.sql": {Data: []byte("SELECT 1;")},
+			},
+			expectError: true,
+		},
+		{
+			name: "badly named file",
+			files: fstest.MapFS{
+				"migrations/0001_users.sql": {Data: []byte("SELECT 1;")},
+			},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migrations, err := Load(test.files, "migrations")
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("did not expect error, got %v", err)
+			}
+
+			var versions []int
+			for _, m := range migrations {
+				versions = append(versions, m.Version)
+			}
+			if len(versions) != len(test.expected) {
+				t.Fatalf("expected versions %v, got %v", test.expected, versions)
+			}
+			for i := range versions {
+				if versions[i] != test.expected[i] {
+					t.Errorf("expected versions %v, got %v", test.expected, versions)
+				}
+			}
+		})
+	}
+}
+
+func TestUp(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	files := fstest.MapFS{
+		"migrations/0001_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"migrations/0001_a.down.sql": {Data: []byte("SELECT 1;")},
+		"migrations/0002_b.up.sql":   {Data: []byte("CREATE TABLE b (id INTEGER);")},
+		"migrations/0002_b.down.sql": {Data: []byte("SELECT 1;")},
+	}
+
+	ran, err := Up(ctx, db, mustLoad(t, files))
+	if err != nil {
+		t.Fatalf("did not expect error, got %v", err)
+	}
+	if len(ran) != 2 || !tableExists(t, db, "a") || !tableExists(t, db, "b") {
+		t.Errorf("expected both migrations to be applied, applied %d", len(ran))
+	}
+
+	// running again should be a no-op...
+	if ran, err = Up(ctx, db, mustLoad(t, files)); err != nil || len(ran) != 0 {
+		t.Errorf("expected nothing to be applied the second time, applied %d with error %v", len(ran), err)
+	}
+
+	// ...until a new migration shows up
+	files["migrations/0003_c.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE c (id INTEGER);")}
+	files["migrations/0003_c.down.sql"] = &fstest.MapFile{Data: []byte("DROP TABLE c;")}
+	if ran, err = Up(ctx, db, mustLoad(t, files)); err != nil || len(ran) != 1 || ran[0].Version != 3 {
+		t.Errorf("expected only migration 3 to be applied, applied %v with error %v", ran, err)
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	// reading the version of a database nothing has touched yet shouldn't create the ledger
+	if version, err := SchemaVersion(ctx, db); err != nil || version != 0 {
+		t.Fatalf("expected version 0, got %d with error %v", version, err)
+	}
+	if tableExists(t, db, "schema_migrations") {
+		t.Fatalf("expected ledger not to be created")
+	}
+
+	files := fstest.MapFS{
+		"migrations/0001_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"migrations/0001_a.down.sql": {Data: []byte("DROP TABLE a;")},
+		"migrations/0002_b.up.sql":   {Data: []byte("CREATE TABLE b (id INTEGER);")},
+		"migrations/0002_b.down.sql": {Data: []byte("DROP TABLE b;")},
+	}
+	if _, err := Up(ctx, db, mustLoad(t, files)); err != nil {
+		t.Fatalf("did not expect error, got %v", err)
+	}
+	if version, err := SchemaVersion(ctx, db); err != nil || version != 2 {
+		t.Fatalf("expected version 2, got %d with error %v", version, err)
+	}
+}
+
+func TestUpRefusesEditedMigration(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	files := fstest.MapFS{
+		"migrations/0001_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"migrations/0001_a.down.sql": {Data: []byte("SELECT 1;")},
+	}
+	if _, err := Up(ctx, db, mustLoad(t, files)); err != nil {
+		t.Fatalf("did not expect error, got %v", err)
+	}
+
+	files["migrations/0001_a.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE a (id INTEGER, name TEXT);")}
+	files["migrations/0002_b.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE b (id INTEGER);")}
+	files["migrations/0002_b.down.sql"] = &fstest.MapFile{Data: []byte("DROP TABLE b;")}
+	_, err := Up(ctx, db, mustLoad(t, files))
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected error %v, got %v", ErrChecksumMismatch, err)
+	}
+	if tableExists(t, db, "b") {
+		t.Errorf("expected no migrations to be applied after an edited one was found")
+	}
+
+	delete(files, "migrations/0001_a.up.sql")
+	delete(files, "migrations/0001_a.down.sql")
+	if _, err = Up(ctx, db, mustLoad(t, files)); !errors.Is(err, ErrUnknownMigration) {
+		t.Errorf("expected error %v, got %v", ErrUnknownMigration, err)
+	}
+}
+
+func TestUpRollsBackFailedMigration(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	files := fstest.MapFS{
+		"migrations/0001_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"migrations/0001_a.down.sql": {Data: []byte("SELECT 1;")},
+		"migrations/0002_b.up.sql":   {Data: []byte("CREATE TABLE b (id INTEGER); SELECT * FROM not_a_table;")},
+		"migrations/0002_b.down.sql": {Data: []byte("SELECT 1;")},
+	}
+
+	ran, err := Up(ctx, db, mustLoad(t, files))
+	if err == nil {
+		t.Fatalf("expected an error, got none")
+	}
+	if len(ran) != 1 || !tableExists(t, db, "a") {
+		t.Errorf("expected the first migration to stay applied")
+	}
+	if tableExists(t, db, "b") {
+		t.Errorf("expected the failed migration to be rolled back")
+	}
+
+	applied, err := Applied(ctx, db)
+	if err != nil || len(applied) != 1 {
+		t.Errorf("expected only the first migration in the ledger, got %v with error %v", applied, err)
+	}
+}
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	discord_id TEXT UNIQUE NOT NULL
+);
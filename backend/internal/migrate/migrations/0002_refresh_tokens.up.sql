@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	jti TEXT PRIMARY KEY,
+	sub TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	expires_at INTEGER NOT NULL
+);
@@ -0,0 +1,5 @@
+-- access tokens are stateless, so logging out blocklists their jti until they'd have expired anyway
+CREATE TABLE IF NOT EXISTS revoked_access_tokens (
+	jti TEXT PRIMARY KEY,
+	expires_at INTEGER NOT NULL
+);
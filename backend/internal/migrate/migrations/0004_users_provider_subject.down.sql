@@ -0,0 +1,11 @@
+-- only Discord users can be represented before this migration, so anyone who logged in with another provider is dropped
+CREATE TABLE users_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	discord_id TEXT UNIQUE NOT NULL
+);
+
+INSERT INTO users_old (id, discord_id)
+SELECT id, subject FROM users WHERE provider = 'discord';
+
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
@@ -0,0 +1,14 @@
+-- users can log in with more than just Discord now, so they're identified by which provider vouched for them and that provider's ID for them.
+-- SQLite can't change a column's constraints in place, so the table is rebuilt and existing users are carried over as Discord users.
+CREATE TABLE users_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	provider TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	UNIQUE(provider, subject)
+);
+
+INSERT INTO users_new (id, provider, subject)
+SELECT id, 'discord', discord_id FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
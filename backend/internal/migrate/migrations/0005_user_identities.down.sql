@@ -0,0 +1,15 @@
+-- users can only have one identity before this migration, so each keeps the one they linked first and the rest are dropped
+CREATE TABLE users_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	provider TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	UNIQUE(provider, subject)
+);
+
+INSERT INTO users_old (id, provider, subject)
+SELECT user_id, provider, subject FROM user_identities
+WHERE id IN (SELECT MIN(id) FROM user_identities GROUP BY user_id);
+
+DROP TABLE user_identities;
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
@@ -0,0 +1,25 @@
+-- a user can now log in with more than one provider, so who vouched for them moves out of users into its own table, one row per linked
+--  identity. existing users keep the identity they signed up with as their first one.
+-- the migrator doesn't enforce foreign keys, so rebuilding users below doesn't cascade into refresh_tokens or user_identities
+CREATE TABLE user_identities (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	provider TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	UNIQUE(provider, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities(user_id);
+
+INSERT INTO user_identities (user_id, provider, subject)
+SELECT id, provider, subject FROM users;
+
+CREATE TABLE users_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT
+);
+
+INSERT INTO users_new (id)
+SELECT id FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS refresh_tokens_family_idx;
+ALTER TABLE refresh_tokens DROP COLUMN consumed;
+ALTER TABLE refresh_tokens DROP COLUMN family;
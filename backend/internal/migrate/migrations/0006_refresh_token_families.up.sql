@@ -0,0 +1,9 @@
+-- every refresh token descends from the one issued at login. tracking that lineage (family), and which tokens were already rotated
+--  out (consumed), lets the auth service spot a stolen token being replayed and revoke the whole family
+ALTER TABLE refresh_tokens ADD COLUMN family TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN consumed INTEGER NOT NULL DEFAULT 0;
+
+-- tokens issued before families existed each start their own, otherwise replaying any one of them would revoke everyone's
+UPDATE refresh_tokens SET family = jti WHERE family = '';
+
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens(family);
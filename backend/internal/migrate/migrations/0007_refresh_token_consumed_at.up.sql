@@ -0,0 +1,3 @@
+-- when a refresh token was rotated out, so a request racing the one that rotated it (e.g. from another tab) isn't mistaken for a replay.
+--  tokens consumed before this was tracked are left at 0, i.e. long enough ago that replaying them still revokes their family
+ALTER TABLE refresh_tokens ADD COLUMN consumed_at INTEGER NOT NULL DEFAULT 0;
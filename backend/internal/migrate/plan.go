@@ -0,0 +1,127 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+type Direction int
+
+const (
+	DirectionUp Direction = iota
+	DirectionDown
+)
+
+// One migration being applied or rolled back. A plan is a list of these, run in order.
+type Step struct {
+	Migration Migration
+	Direction Direction
+}
+
+var ErrNothingApplied error = errors.New("no migrations have been applied")
+var ErrUnknownVersion error = errors.New("there is no migration with that version")
+
+// Returns the SQL the step runs, which depends on which way it's going
+func (s Step) SQL() string {
+	if s.Direction == DirectionUp {
+		return s.Migration.Up
+	}
+	return s.Migration.Down
+}
+
+func (s Step) String() string {
+	verb := "apply"
+	if s.Direction == DirectionDown {
+		verb = "roll back"
+	}
+	return fmt.Sprintf("%s %04d_%s", verb, s.Migration.Version, s.Migration.Name)
+}
+
+// Returns the version of the newest applied migration, or 0 if nothing has been applied
+func Current(applied []AppliedMigration) int {
+	if len(applied) == 0 {
+		return 0
+	}
+	return applied[len(applied)-1].Version
+}
+
+// Returns the version of the newest migration, i.e. what the schema's version will be once everything is applied
+func Latest(migrations []Migration) int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+func isApplied(applied []AppliedMigration, version int) bool {
+	return slices.ContainsFunc(applied, func(a AppliedMigration) bool { return a.Version == version })
+}
+
+// Plans applying every migration that hasn't been applied yet, oldest first
+func PlanUp(applied []AppliedMigration, migrations []Migration) []Step {
+	var plan []Step
+	for _, m := range migrations {
+		if !isApplied(applied, m.Version) {
+			plan = append(plan, Step{m, DirectionUp})
+		}
+	}
+	return plan
+}
+
+// Plans rolling back the n newest applied migrations, newest first. Assumes Verify has passed, so every applied migration has a file.
+// On failure (n is more than what's applied), returns nil and error.
+func PlanDown(applied []AppliedMigration, migrations []Migration, n int) ([]Step, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("can't roll back %d migrations", n)
+	}
+	if n > len(applied) {
+		return nil, fmt.Errorf("can't roll back %d migrations, only %d are applied", n, len(applied))
+	}
+
+	var plan []Step
+	for _, a := range slices.Backward(applied[len(applied)-n:]) {
+		i := slices.IndexFunc(migrations, func(m Migration) bool { return m.Version == a.Version })
+		plan = append(plan, Step{migrations[i], DirectionDown})
+	}
+	return plan, nil
+}
+
+// Plans moving the schema to exactly the given version: applying pending migrations up to and including it, or rolling back everything after it.
+// Version 0 rolls back everything.
+// On failure (no migration has that version), returns nil and error.
+func PlanGoto(applied []AppliedMigration, migrations []Migration, version int) ([]Step, error) {
+	if version != 0 && !slices.ContainsFunc(migrations, func(m Migration) bool { return m.Version == version }) {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	var plan []Step
+	// roll back anything newer than the target first...
+	for _, a := range slices.Backward(applied) {
+		if a.Version > version {
+			i := slices.IndexFunc(migrations, func(m Migration) bool { return m.Version == a.Version })
+			plan = append(plan, Step{migrations[i], DirectionDown})
+		}
+	}
+	// ...then fill in anything missing up to it
+	for _, m := range migrations {
+		if m.Version <= version && !isApplied(applied, m.Version) {
+			plan = append(plan, Step{m, DirectionUp})
+		}
+	}
+	return plan, nil
+}
+
+// Plans rolling back the newest applied migration and applying it again, e.g. to test its down file
+// On failure (nothing is applied), returns nil and error.
+func PlanRedo(applied []AppliedMigration, migrations []Migration) ([]Step, error) {
+	if len(applied) == 0 {
+		return nil, ErrNothingApplied
+	}
+
+	down, err := PlanDown(applied, migrations, 1)
+	if err != nil {
+		return nil, err
+	}
+	return append(down, Step{down[0].Migration, DirectionUp}), nil
+}
@@ -0,0 +1,289 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+// Three migrations that each create a table, with down files that drop it again
+var reversibleFiles = fstest.MapFS{
+	"migrations/0001_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER);")},
+	"migrations/0001_a.down.sql": {Data: []byte("DROP TABLE a;")},
+	"migrations/0002_b.up.sql":   {Data: []byte("CREATE TABLE b (id INTEGER);")},
+	"migrations/0002_b.down.sql": {Data: []byte("DROP TABLE b;")},
+	"migrations/0003_c.up.sql":   {Data: []byte("CREATE TABLE c (id INTEGER);")},
+	"migrations/0003_c.down.sql": {Data: []byte("DROP TABLE c;")},
+}
+
+func mustApplied(t *testing.T, db *sql.DB) []AppliedMigration {
+	applied, err := Applied(context.Background(), db)
+	if err != nil {
+		t.Fatalf("could not read applied migrations: %v", err)
+	}
+	return applied
+}
+
+// Describes a plan as e.g. ["-3", "-2", "+2"], where - is rolling back and + is applying
+func describe(plan []Step) []string {
+	var steps []string
+	for _, step := range plan {
+		sign := "+"
+		if step.Direction == DirectionDown {
+			sign = "-"
+		}
+		steps = append(steps, sign+string(rune('0'+step.Migration.Version)))
+	}
+	return steps
+}
+
+func TestPlans(t *testing.T) {
+	migrations := mustLoad(t, reversibleFiles)
+	// as if 1 and 2 have been applied
+	applied := []AppliedMigration{{Version: 1}, {Version: 2}}
+
+	var tests = []struct {
+		name        string
+		plan        func() ([]Step, error)
+		expected    []string
+		expectError bool
+	}{
+		{
+			name:     "up",
+			plan:     func() ([]Step, error) { return PlanUp(applied, migrations), nil },
+			expected: []string{"+3"},
+		},
+		{
+			name:     "down 2",
+			plan:     func() ([]Step, error) { return PlanDown(applied, migrations, 2) },
+			expected: []string{"-2", "-1"},
+		},
+		{
+			name:        "down more than applied",
+			plan:        func() ([]Step, error) { return PlanDown(applied, migrations, 3) },
+			expectError: true,
+		},
+		{
+			name:     "goto newer version",
+			plan:     func() ([]Step, error) { return PlanGoto(applied, migrations, 3) },
+			expected: []string{"+3"},
+		},
+		{
+			name:     "goto older version",
+			plan:     func() ([]Step, error) { return PlanGoto(applied, migrations, 1) },
+			expected: []string{"-2"},
+		},
+		{
+			name:     "goto 0",
+			plan:     func() ([]Step, error) { return PlanGoto(applied, migrations, 0) },
+			expected: []string{"-2", "-1"},
+		},
+		{
+			name:        "goto unknown version",
+			plan:        func() ([]Step, error) { return PlanGoto(applied, migrations, 7) },
+			expectError: true,
+		},
+		{
+			name:     "redo",
+			plan:     func() ([]Step, error) { return PlanRedo(applied, migrations) },
+			expected: []string{"-2", "+2"},
+		},
+		{
+			name:        "redo with nothing applied",
+			plan:        func() ([]Step, error) { return PlanRedo(nil, migrations) },
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan, err := test.plan()
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got plan %v", describe(plan))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("did not expect error, got %v", err)
+			}
+			if got := describe(plan); !slices.Equal(got, test.expected) {
+				t.Errorf("expected plan %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestRunDownAndUp(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	migrations := mustLoad(t, reversibleFiles)
+	if _, err := Up(ctx, db, migrations); err != nil {
+		t.Fatalf("did not expect error, got %v", err)
+	}
+
+	plan, err := PlanGoto(mustApplied(t, db), migrations, 1)
+	if err != nil {
+		t.Fatalf("could not plan: %v", err)
+	}
+	if _, err = Run(ctx, db, plan); err != nil {
+		t.Fatalf("did not expect error, got %v", err)
+	}
+
+	if !tableExists(t, db, "a") || tableExists(t, db, "b") || tableExists(t, db, "c") {
+		t.Errorf("expected only table a to exist after going to version 1")
+	}
+	if current := Current(mustApplied(t, db)); current != 1 {
+		t.Errorf("expected schema to be at version 1, got %d", current)
+	}
+
+	// and back up again
+	if _, err = Up(ctx, db, migrations); err != nil {
+		t.Fatalf("did not expect error, got %v", err)
+	}
+	if current := Current(mustApplied(t, db)); current != 3 {
+		t.Errorf("expected schema to be at version 3, got %d", current)
+	}
+}
+
+func TestEmbeddedMigrationsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("could not load embedded migrations: %v", err)
+	}
+	if _, err = Up(ctx, db, migrations); err != nil {
+		t.Fatalf("embedded migrations failed to apply: %v", err)
+	}
+
+	plan, err := PlanGoto(mustApplied(t, db), migrations, 0)
+	if err != nil {
+		t.Fatalf("could not plan: %v", err)
+	}
+	if _, err = Run(ctx, db, plan); err != nil {
+		t.Fatalf("embedded migrations failed to roll back: %v", err)
+	}
+
+	// everything should be gone except the ledger itself
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_migrations', 'sqlite_sequence')").Scan(&count)
+	if count != 0 {
+		t.Errorf("expected every table to be dropped, %d remain", count)
+	}
+
+	if _, err = Up(ctx, db, migrations); err != nil {
+		t.Errorf("embedded migrations failed to apply after rolling back: %v", err)
+	}
+}
+
+// 0005 moves users' identities out into their own table, so make sure existing users survive it both ways
+func TestUserIdentitiesMigrationKeepsUsers(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("could not load embedded migrations: %v", err)
+	}
+
+	plan, err := PlanGoto(nil, migrations, 4)
+	if err != nil {
+		t.Fatalf("could not plan: %v", err)
+	}
+	if _, err = Run(ctx, db, plan); err != nil {
+		t.Fatalf("could not migrate to version 4: %v", err)
+	}
+	if _, err = db.Exec("INSERT INTO users (id, provider, subject) VALUES (7, 'discord', '1234'), (8, 'github', '5678')"); err != nil {
+		t.Fatalf("could not insert users: %v", err)
+	}
+
+	if _, err = Up(ctx, db, migrations); err != nil {
+		t.Fatalf("could not migrate to latest: %v", err)
+	}
+	var userID int
+	if err = db.QueryRow("SELECT user_id FROM user_identities WHERE provider = 'github' AND subject = '5678'").Scan(&userID); err != nil || userID != 8 {
+		t.Fatalf("expected github identity to belong to user 8, got %d, %v", userID, err)
+	}
+
+	// a second identity for user 7, linked after the first, which can't be kept when rolling back
+	db.Exec("INSERT INTO user_identities (user_id, provider, subject) VALUES (7, 'oidc', 'abc')")
+	plan, err = PlanGoto(mustApplied(t, db), migrations, 4)
+	if err != nil {
+		t.Fatalf("could not plan: %v", err)
+	}
+	if _, err = Run(ctx, db, plan); err != nil {
+		t.Fatalf("could not roll back to version 4: %v", err)
+	}
+
+	var provider string
+	if err = db.QueryRow("SELECT provider FROM users WHERE id = 7").Scan(&provider); err != nil || provider != "discord" {
+		t.Errorf("expected user 7 to keep their first identity, got %q, %v", provider, err)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	if count != 2 {
+		t.Errorf("expected 2 users after rolling back, got %d", count)
+	}
+}
+
+// Databases set up by the migrator before it had versioned migrations have the original users and refresh_tokens tables, but no ledger.
+// Migrating them should pick up from there, keeping their users and tokens.
+func TestUpFromBaselineSchema(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	_, err := db.Exec(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			discord_id TEXT UNIQUE NOT NULL
+		);
+		CREATE TABLE refresh_tokens (
+			jti TEXT PRIMARY KEY,
+			sub TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			expires_at INTEGER NOT NULL
+		);
+		INSERT INTO users (id, discord_id) VALUES (7, '1234');
+		INSERT INTO refresh_tokens (jti, sub, expires_at) VALUES ('a', '7', 4102444800), ('b', '7', 4102444800);
+	`)
+	if err != nil {
+		t.Fatalf("could not create baseline schema: %v", err)
+	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("could not load embedded migrations: %v", err)
+	}
+	if _, err = Up(ctx, db, migrations); err != nil {
+		t.Fatalf("could not migrate baseline schema: %v", err)
+	}
+	if current := Current(mustApplied(t, db)); current != migrations[len(migrations)-1].Version {
+		t.Errorf("expected schema to be at the latest version, got %d", current)
+	}
+
+	var userID int
+	if err = db.QueryRow("SELECT user_id FROM user_identities WHERE provider = 'discord' AND subject = '1234'").Scan(&userID); err != nil || userID != 7 {
+		t.Errorf("expected discord user to be kept as user 7, got %d, %v", userID, err)
+	}
+	// each legacy token is its own family, so replaying one can't revoke anyone else's
+	rows, err := db.Query("SELECT jti, family, consumed FROM refresh_tokens ORDER BY jti")
+	if err != nil {
+		t.Fatalf("could not read refresh tokens: %v", err)
+	}
+	defer rows.Close()
+	count := 0
+	for rows.Next() {
+		var jti, family string
+		var consumed int
+		if err = rows.Scan(&jti, &family, &consumed); err != nil {
+			t.Fatalf("could not read refresh token: %v", err)
+		}
+		if family != jti || consumed != 0 {
+			t.Errorf("expected token %s to be its own unconsumed family, got family %q consumed %d", jti, family, consumed)
+		}
+		count++
+	}
+	if count != 2 {
+		t.Errorf("expected both refresh tokens to be kept, got %d", count)
+	}
+}
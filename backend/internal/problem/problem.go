@@ -0,0 +1,64 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Something that went wrong handling a request, as the client should hear about it. Only ever holds what's safe for the client to see:
+// what actually went wrong, e.g. a database or provider error, goes in the logs, which the response's request ID leads back to.
+type Problem struct {
+	// The HTTP status to respond with
+	Status int
+	// Short and stable, for clients to tell problems apart by, e.g. "not_logged_in"
+	Code string
+	// For people, e.g. "not logged in"
+	Message string
+}
+
+// Sent in place of anything that went wrong on our end
+var Internal = New(http.StatusInternalServerError, "internal_error", "something went wrong on our end")
+var NotLoggedIn = New(http.StatusUnauthorized, "not_logged_in", "not logged in")
+
+func New(status int, code string, message string) *Problem {
+	return &Problem{Status: status, Code: code, Message: message}
+}
+
+func (p *Problem) Error() string {
+	return p.Message
+}
+
+// An RFC 9457 problem details document, with the code and request ID as extension members
+type details struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail"`
+	Instance  string `json:"instance"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Responds with p as application/problem+json, e.g.
+//
+//	{"type":"about:blank","title":"Unauthorized","status":401,"detail":"not logged in","instance":"/identities","code":"not_logged_in","request_id":"4bf9..."}
+//
+// The request ID is the one middleware.RequestID already put in the response's X-Request-ID header, if any.
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	h := w.Header()
+	// in case the handler set one for the response it meant to send instead
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/problem+json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+
+	json.NewEncoder(w).Encode(details{
+		Type:      "about:blank",
+		Title:     http.StatusText(p.Status),
+		Status:    p.Status,
+		Detail:    p.Message,
+		Instance:  r.URL.Path,
+		Code:      p.Code,
+		RequestID: h.Get("X-Request-ID"),
+	})
+}
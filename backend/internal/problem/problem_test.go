@@ -0,0 +1,39 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+	// as middleware.RequestID leaves it
+	rec.Header().Set("X-Request-ID", "abc123")
+	Write(rec, httptest.NewRequest("GET", "/identities", nil), NotLoggedIn)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("expected problem+json, got %s", ct)
+	}
+
+	var got details
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("could not decode problem: %v", err)
+	}
+	want := details{
+		Type:      "about:blank",
+		Title:     "Unauthorized",
+		Status:    http.StatusUnauthorized,
+		Detail:    "not logged in",
+		Instance:  "/identities",
+		Code:      "not_logged_in",
+		RequestID: "abc123",
+	}
+	if got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"wingbox.spencrc/internal/problem"
+)
+
+// Largest request body DecodeJSON will read
+const MAX_JSON_BODY = 1 << 20
+
+// A handler that returns what went wrong rather than responding to it itself. Use it with chain.Chain's Then, e.g.
+//
+//	s.Handle("GET /boxes/{id}", s.BaseChain.Then(server.HandlerFunc(getBox)))
+//
+// If it returns a *problem.Problem, or wraps one, the client gets that problem. Anything else is problem.Internal.
+// Wrap errors with whatever will help make sense of them in the logs, e.g.
+//
+//	return fmt.Errorf("could not fetch token from provider %s: %w: %w", name, problemProviderFailed, err)
+//
+// Server errors are logged through the request's logger, as are client errors wrapping more than just the problem. An error must only be
+// returned if the handler hasn't started responding yet.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+func (f HandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := f(w, r)
+	if err == nil {
+		return
+	}
+
+	p := problem.Internal
+	errors.As(err, &p)
+
+	logger := LoggerFrom(r.Context())
+	switch {
+	case p.Status >= 500:
+		logger.Error("request failed", "code", p.Code, "err", err)
+	case err != error(p):
+		logger.Warn("request refused", "code", p.Code, "err", err)
+	}
+	problem.Write(w, r, p)
+}
+
+// Decodes the request's JSON body into dst, which must be a pointer. Bodies over MAX_JSON_BODY, with fields dst doesn't have, or with
+// anything after the JSON are refused. If dst has a Validate method, it's called once decoded, and what it returns is passed on to the
+// client, so its errors should be written for them. Returns a *problem.Problem describing what was wrong, ready to return from a HandlerFunc.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mediaType, _, _ := mime.ParseMediaType(ct); mediaType != "application/json" {
+			return problem.New(http.StatusUnsupportedMediaType, "unsupported_media_type", "request body must be application/json")
+		}
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_JSON_BODY))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(dst)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("request body must only contain one JSON value")
+	}
+
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return problem.New(http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("request body must be at most %d bytes", tooLarge.Limit))
+	case errors.Is(err, io.EOF):
+		return problem.New(http.StatusBadRequest, "invalid_json", "request body is empty")
+	case err != nil:
+		return problem.New(http.StatusBadRequest, "invalid_json", err.Error())
+	}
+
+	if validator, ok := dst.(interface{ Validate() error }); ok {
+		if err = validator.Validate(); err != nil {
+			var p *problem.Problem
+			if errors.As(err, &p) {
+				return p
+			}
+			return problem.New(http.StatusUnprocessableEntity, "invalid_request", err.Error())
+		}
+	}
+	return nil
+}
+
+// Responds with v as JSON. Returns the error from encoding it, though the status will have been sent by then.
+func WriteJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
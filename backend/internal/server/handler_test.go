@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"wingbox.spencrc/internal/problem"
+)
+
+var problemTeapot = problem.New(http.StatusTeapot, "teapot", "short and stout")
+
+func TestHandlerFunc(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode string
+		status   int
+	}{
+		{"problem", problemTeapot, "teapot", http.StatusTeapot},
+		{"wrapped problem", fmt.Errorf("could not brew: %w: %w", problemTeapot, errors.New("no coffee")), "teapot", http.StatusTeapot},
+		// whatever else went wrong stays in the logs
+		{"anything else", errors.New("database is locked"), "internal_error", http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := HandlerFunc(func(w http.ResponseWriter, r *http.Request) error { return tt.err })
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+			var body struct {
+				Code   string `json:"code"`
+				Detail string `json:"detail"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("could not decode problem: %v", err)
+			}
+			if rec.Code != tt.status || body.Code != tt.wantCode {
+				t.Errorf("expected %d %s, got %d %s", tt.status, tt.wantCode, rec.Code, body.Code)
+			}
+			if strings.Contains(body.Detail, "no coffee") || strings.Contains(body.Detail, "locked") {
+				t.Errorf("internal error leaked to the client: %s", body.Detail)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return WriteJSON(w, http.StatusCreated, map[string]string{"hello": "world"})
+	}).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusCreated || rec.Header().Get("Content-Type") != "application/json" || rec.Body.String() != `{"hello":"world"}`+"\n" {
+		t.Errorf("expected handler's own response to be left alone, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+type testBox struct {
+	Name string `json:"name"`
+}
+
+func (b testBox) Validate() error {
+	if b.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{"valid", "application/json", `{"name":"wingbox"}`, 0},
+		{"charset", "application/json; charset=utf-8", `{"name":"wingbox"}`, 0},
+		{"no content type", "", `{"name":"wingbox"}`, 0},
+		{"wrong content type", "text/plain", `{"name":"wingbox"}`, http.StatusUnsupportedMediaType},
+		{"empty", "application/json", "", http.StatusBadRequest},
+		{"malformed", "application/json", `{"name":`, http.StatusBadRequest},
+		{"unknown field", "application/json", `{"name":"wingbox","admin":true}`, http.StatusBadRequest},
+		{"trailing data", "application/json", `{"name":"wingbox"}{"name":"again"}`, http.StatusBadRequest},
+		{"too large", "application/json", `{"name":"` + strings.Repeat("a", MAX_JSON_BODY) + `"}`, http.StatusRequestEntityTooLarge},
+		{"invalid", "application/json", `{"name":""}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			var box testBox
+			err := DecodeJSON(httptest.NewRecorder(), req, &box)
+			if tt.wantStatus == 0 {
+				if err != nil || box.Name != "wingbox" {
+					t.Fatalf("expected body to decode, got %+v, %v", box, err)
+				}
+				return
+			}
+
+			var p *problem.Problem
+			if !errors.As(err, &p) || p.Status != tt.wantStatus {
+				t.Errorf("expected a problem with status %d, got %v", tt.wantStatus, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"wingbox.spencrc/internal/migrate"
+)
+
+// How long all the readiness checks get, together, before /readyz gives up on them
+const READINESS_TIMEOUT = 2 * time.Second
+
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// What /readyz responds with, e.g. {"status":"unavailable","checks":{"database":"ok","schema":"schema is at version 4, expected 5"}}
+type readinessReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Registers a check /readyz runs on every request, on top of the database and schema checks every server has. The server is only ready
+// while all of them return nil, so checks should be cheap, and only fail for things that stop the service from doing its job.
+func (s *Server) AddReadinessCheck(name string, check func(ctx context.Context) error) {
+	s.readinessChecks = append(s.readinessChecks, readinessCheck{name, check})
+}
+
+// Checks the migrator has brought the schema up to latest, the newest migration this binary was built with. A newer schema is fine, so the
+// migrator can run before the services are redeployed, as long as migrations stay backwards compatible.
+func checkSchema(latest int, version func(ctx context.Context) (int, error)) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		current, err := version(ctx)
+		if err != nil {
+			return err
+		}
+		if current < latest {
+			return fmt.Errorf("schema is at version %d, expected %d", current, latest)
+		}
+		return nil
+	}
+}
+
+// Registers /healthz, /readyz and the checks every server has. These skip BaseChain, so probes don't flood the request log.
+func (s *Server) registerHealthRoutes() {
+	migrations, err := migrate.Migrations()
+	if err != nil {
+		s.LogFatal("could not load migrations", "err", err)
+	}
+
+	s.AddReadinessCheck("database", s.Db.PingContext)
+	s.AddReadinessCheck("schema", checkSchema(migrate.Latest(migrations), func(ctx context.Context) (int, error) {
+		return migrate.SchemaVersion(ctx, s.Db)
+	}))
+
+	s.mux.HandleFunc("GET /healthz", s.Healthz)
+	s.mux.HandleFunc("GET /readyz", s.Readyz)
+}
+
+// Liveness: responds 200 as long as the process can serve requests at all. Deliberately checks nothing else, as a failing liveness probe
+// gets the container restarted, which wouldn't fix e.g. the database being unreachable.
+func (s *Server) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok\n"))
+}
+
+// Readiness: runs every readiness check, responding 200 if they all pass and 503 otherwise, with what each check said as JSON
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), READINESS_TIMEOUT)
+	defer cancel()
+
+	report := readinessReport{Status: "ok", Checks: map[string]string{}}
+	status := http.StatusOK
+	for _, c := range s.readinessChecks {
+		if err := c.check(ctx); err != nil {
+			report.Checks[c.name] = err.Error()
+			report.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+			s.Logger.Warn("readiness check failed", "check", c.name, "err", err)
+			continue
+		}
+		report.Checks[c.name] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// Probes the server's /readyz on port, exiting with 0 if it's ready and 1 if it isn't. For `<binary> healthcheck` in compose's
+// healthcheck, as the distroless images have no curl or wget to do it with.
+func Healthcheck(port uint64) {
+	client := &http.Client{Timeout: READINESS_TIMEOUT + time.Second}
+	res, err := client.Get("http://127.0.0.1:" + strconv.FormatUint(port, 10) + "/readyz")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "not ready:", res.Status)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
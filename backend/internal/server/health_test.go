@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	_ "modernc.org/sqlite"
+	"wingbox.spencrc/internal/migrate"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", "file::memory:?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	// every connection to :memory: is its own database, so make sure we only ever have one
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func readyz(t *testing.T, s *Server) (int, readinessReport) {
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+	var report readinessReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("could not decode readiness report: %v", err)
+	}
+	return rec.Code, report
+}
+
+func TestReadyz(t *testing.T) {
+	s := newTestServer()
+	s.Db = newTestDB(t)
+	s.registerHealthRoutes()
+
+	// the migrator hasn't run yet
+	code, report := readyz(t, s)
+	if code != http.StatusServiceUnavailable || report.Checks["database"] != "ok" || report.Checks["schema"] == "ok" {
+		t.Fatalf("expected only schema check to fail, got %d %+v", code, report)
+	}
+
+	migrations, err := migrate.Migrations()
+	if err != nil {
+		t.Fatalf("could not load migrations: %v", err)
+	}
+	if _, err = migrate.Up(context.Background(), s.Db, migrations); err != nil {
+		t.Fatalf("could not migrate: %v", err)
+	}
+	if code, report = readyz(t, s); code != http.StatusOK || report.Status != "ok" {
+		t.Fatalf("expected ready once migrated, got %d %+v", code, report)
+	}
+
+	// services' own checks count too
+	s.AddReadinessCheck("jwks", func(context.Context) error { return errors.New("auth is down") })
+	if code, report = readyz(t, s); code != http.StatusServiceUnavailable || report.Checks["jwks"] != "auth is down" {
+		t.Fatalf("expected extra check to fail readiness, got %d %+v", code, report)
+	}
+
+	// liveness doesn't care
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected healthz to be 200, got %d", rec.Code)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	version := func(v int) func(context.Context) (int, error) {
+		return func(context.Context) (int, error) { return v, nil }
+	}
+
+	if err := checkSchema(5, version(4))(context.Background()); err == nil {
+		t.Errorf("expected older schema to fail")
+	}
+	for _, v := range []int{5, 6} {
+		if err := checkSchema(5, version(v))(context.Background()); err != nil {
+			t.Errorf("expected schema at version %d to pass, got %v", v, err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Serves Metrics in the Prometheus text format at /metrics on metricsPort, unless it's 0. It's a separate listener from the one requests
+// come in on, so metrics stay internal without nginx needing to know to hide them. Stopped by a shutdown hook.
+func (s *Server) serveMetrics() {
+	if s.metricsPort == 0 {
+		return
+	}
+
+	addr := ":" + strconv.FormatUint(s.metricsPort, 10)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		s.LogFatal("Could not listen for metrics", "address", addr, "err", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(s.Metrics, promhttp.HandlerOpts{Registry: s.Metrics}))
+	srv := &http.Server{Handler: mux}
+
+	go func() {
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			s.Logger.Error("metrics server stopped", "err", err)
+		}
+	}()
+	s.OnShutdown("metrics server", srv.Shutdown)
+	s.Logger.Info("Serving metrics", "address", addr)
+}
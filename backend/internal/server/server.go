@@ -1,17 +1,32 @@
 package server
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/XSAM/otelsql"
 	"github.com/lmittmann/tint"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
 	_ "modernc.org/sqlite"
 	"wingbox.spencrc/internal/chain"
 	"wingbox.spencrc/internal/middleware"
+	"wingbox.spencrc/internal/tracing"
 )
 
 type Server struct {
@@ -19,31 +34,150 @@ type Server struct {
 	mux *http.ServeMux
 	Db *sql.DB
 	BaseChain chain.Chain
+	// How long Listen waits for in-flight requests to finish, then for shutdown hooks, once told to stop. see Config
+	ShutdownTimeout time.Duration
+	shutdownHooks []shutdownHook
+	// run by /readyz. see AddReadinessCheck
+	readinessChecks []readinessCheck
+	// Where services register their own metrics. Served on metricsPort, see serveMetrics
+	Metrics *prometheus.Registry
+	metricsPort uint64
+}
+
+type shutdownHook struct {
+	name string
+	run func(ctx context.Context) error
+}
+
+// What every server needs from the environment. Meant to be included in each service's own config, for env.Load to fill.
+type Config struct {
+	// the SQLite database file, shared with the migrator
+	DBPath string `env:"DB_PATH" default:"/db/app.db"`
+	// Keep it under docker's stop grace period (10s by default), or the process is killed before the shutdown hooks run
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" default:"5s"`
+	// Prometheus metrics are served on their own port, so they can't be reached through nginx. 0 turns them off
+	MetricsPort uint64 `env:"METRICS_PORT" default:"9090"`
+	Tracing     tracing.Config
+
+	// Log only 1 in every LOG_SAMPLE_SUCCESS successful requests, to cut down on noise. Failed requests are always logged
+	LogSampleSuccess uint64   `env:"LOG_SAMPLE_SUCCESS" default:"1"`
+	// Paths never logged. /healthz and /readyz don't need listing, as they skip BaseChain and so are never logged anyway
+	LogSkipPaths []string `env:"LOG_SKIP_PATHS"`
+	// Proxies trusted to say who the client is with X-Forwarded-For, i.e. nginx. By default, the private ranges docker networks use
+	TrustedProxies []string `env:"TRUSTED_PROXIES" default:"127.0.0.0/8,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,::1/128,fc00::/7"`
 }
 
 // Creates Logger, creates ServeMux, and creates universal middleware chain. These values are then used to create a Server struct.
-func Init() *Server {
+func Init(cfg Config) *Server {
 	// Initialize logger
 	loggerHandler := tint.NewHandler(os.Stderr, &tint.Options{})
 	logger := slog.New(loggerHandler)
+	// so LoggerFrom has something to fall back on
+	slog.SetDefault(logger)
 
 	// We are using http.NewServeMux() to start up a servemux (router)
 	mux := http.NewServeMux()
 
-	// Set up the database!
-	const DB_PATH = "file:///db/app.db?_pragma=foreign_keys(1)"
-	db, err := sql.Open("sqlite", DB_PATH)
+	// Set up tracing before anything that makes spans
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+
+	// Set up the database! Queries get spans, but only as part of a request's trace, so the pool's housekeeping isn't traced on its own
+	db, err := otelsql.Open("sqlite", "file:"+cfg.DBPath+"?_pragma=foreign_keys(1)",
+		otelsql.WithAttributes(semconv.DBSystemNameSQLite),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	)
 	if err != nil {
 		log.Print(err)
 		os.Exit(1)
 	}
 
+	// Set up metrics, starting with the Go runtime's and the database connection pool's
+	metrics := prometheus.NewRegistry()
+	metrics.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db, "app"),
+	)
+
 	// Set up universal middleware!
+	var trustedProxies []netip.Prefix
+	for _, proxy := range cfg.TrustedProxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			log.Printf("TRUSTED_PROXIES is invalid: %v", err)
+			os.Exit(1)
+		}
+		trustedProxies = append(trustedProxies, prefix)
+	}
 	baseChain := chain.Chain{
-		middleware.LogRequest(logger),
+		middleware.Trace(),
+		middleware.RequestID(logger),
+		middleware.LogRequest(logger, middleware.LogOptions{
+			SampleSuccess:  cfg.LogSampleSuccess,
+			SkipPaths:      cfg.LogSkipPaths,
+			TrustedProxies: trustedProxies,
+		}),
+		middleware.Metrics(metrics),
+		middleware.Recover(logger, metrics),
 	}
 
-	return &Server{logger, mux, db, baseChain}
+	s := &Server{
+		Logger:          logger,
+		mux:             mux,
+		Db:              db,
+		BaseChain:       baseChain,
+		ShutdownTimeout: cfg.ShutdownTimeout,
+		Metrics:         metrics,
+		metricsPort:     cfg.MetricsPort,
+	}
+
+	s.registerHealthRoutes()
+
+	// registered first so they run last, after anything else that might still be using them
+	s.OnShutdown("tracing", shutdownTracing)
+	s.OnShutdown("database", func(ctx context.Context) error {
+		return db.Close()
+	})
+
+	return s
+}
+
+// Registers a hook to run once the server has stopped taking requests, e.g. to stop a background worker. Hooks run in the reverse of the
+// order they were registered, like defers, so something is always torn down before whatever it was set up on top of.
+func (s *Server) OnShutdown(name string, hook func(ctx context.Context) error) {
+	s.shutdownHooks = append(s.shutdownHooks, shutdownHook{name, hook})
+}
+
+// Runs every shutdown hook, even if some fail, logging those that do. Returns whether they all succeeded.
+func (s *Server) runShutdownHooks(ctx context.Context) bool {
+	ok := true
+	for i := len(s.shutdownHooks) - 1; i >= 0; i-- {
+		hook := s.shutdownHooks[i]
+		if err := hook.run(ctx); err != nil {
+			s.Logger.Error("shutdown hook failed", "hook", hook.name, "err", err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// The logger for whatever ctx belongs to. Within a request, that's the one BaseChain set up for it, which tags everything it logs with
+// the request's ID. Otherwise, it's the default logger, which Init sets to the server's own.
+func LoggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := middleware.LoggerFrom(ctx); ok {
+		return logger
+	}
+	return slog.Default()
 }
 
 // Wrapper for ServeMux.Handle
@@ -57,16 +191,58 @@ func (s *Server) LogFatal(msg string, args ...any) {
 	os.Exit(1)
 }
 
-// Begins listening on server's ServeMux at port specified in Init. Logs and exits on error.
+// Serves requests from ln until ctx is cancelled, then stops taking new requests, waits up to ShutdownTimeout for in-flight ones to finish,
+// and runs the shutdown hooks. The hooks run even if serving failed, or draining ran out of time.
+// Returns nil on a clean stop, otherwise the first thing that went wrong.
+func (s *Server) serve(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{Handler: s.mux}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+		// Serve always returns a non-nil error, and it can only get here without Shutdown having been called
+	case <-ctx.Done():
+		s.Logger.Info("Shutting down, draining requests", "timeout", s.ShutdownTimeout)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+
+	if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+		err = fmt.Errorf("could not drain requests: %w", shutdownErr)
+	}
+	if !s.runShutdownHooks(shutdownCtx) && err == nil {
+		err = errors.New("some shutdown hooks failed")
+	}
+	return err
+}
+
+// Begins listening on server's ServeMux at port specified in Init, until SIGINT or SIGTERM (e.g. from docker compose down), then shuts
+// down gracefully. Returns after a clean stop, so the process exits with 0. Logs and exits with 1 on error.
 func (s *Server) Listen(port uint64) {
 	addr := ":" + strconv.FormatUint(port, 10)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// once the first signal has been seen, stop catching them, so a second one kills the process straight away
+	context.AfterFunc(ctx, stop)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		s.runShutdownHooks(context.Background())
+		s.LogFatal("Could not listen", "address", addr, "err", err)
+	}
 	s.Logger.Info("Starting server", "address", addr)
+	s.serveMetrics()
 
-	// Use http.listenAndServe() to start a new server. We pass the port and the router.
-	//  If http.ListenAndServe() returns, then it means it's errored and we log it.
-	err := http.ListenAndServe(addr, s.mux)
-	// We can only get here if something's gone wrong!
-	s.Db.Close()
-	// Functionally same as log.Fatal, but using custom, structured logger
-	s.LogFatal("Stopping server", "err", err)
-}
\ No newline at end of file
+	if err = s.serve(ctx, ln); err != nil {
+		// Functionally same as log.Fatal, but using custom, structured logger
+		s.LogFatal("Stopping server", "err", err)
+	}
+	s.Logger.Info("Server stopped")
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTestServer() *Server {
+	return &Server{Logger: slog.New(slog.DiscardHandler), mux: http.NewServeMux(), ShutdownTimeout: time.Second}
+}
+
+// In-flight requests should finish before the hooks run, and the hooks should run newest first
+func TestServeDrainsThenRunsHooks(t *testing.T) {
+	s := newTestServer()
+
+	var order []string
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		order = append(order, "request")
+		io.WriteString(w, "done")
+	}))
+	s.OnShutdown("database", func(context.Context) error {
+		order = append(order, "database")
+		return nil
+	})
+	s.OnShutdown("worker", func(context.Context) error {
+		order = append(order, "worker")
+		return nil
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- s.serve(ctx, ln)
+	}()
+
+	resErr := make(chan error, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			res.Body.Close()
+		}
+		resErr <- err
+	}()
+
+	<-started
+	cancel()
+	// give Shutdown a moment to start, so the request really is in flight while draining
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if err = <-resErr; err != nil {
+		t.Fatalf("in-flight request was cut off: %v", err)
+	}
+	if err = <-served; err != nil {
+		t.Fatalf("expected clean stop, got %v", err)
+	}
+	if want := []string{"request", "worker", "database"}; !slices.Equal(order, want) {
+		t.Fatalf("expected %v, got %v", want, order)
+	}
+}
+
+func TestServeReportsFailedHooks(t *testing.T) {
+	s := newTestServer()
+
+	ran := false
+	s.OnShutdown("database", func(context.Context) error {
+		ran = true
+		return nil
+	})
+	s.OnShutdown("worker", func(context.Context) error {
+		return errors.New("worker stuck")
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err = s.serve(ctx, ln); err == nil {
+		t.Fatalf("expected failed hook to be reported")
+	}
+	if !ran {
+		t.Fatalf("expected remaining hooks to run after one failed")
+	}
+}
@@ -0,0 +1,61 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Where spans go. Named after the variables the OpenTelemetry SDKs use, so they mean the same thing here as anywhere else: the OTLP
+// exporter reads OTEL_EXPORTER_OTLP_ENDPOINT (e.g. http://otel-collector:4318) itself, the service is named by OTEL_SERVICE_NAME, and the
+// SDK picks its sampler from OTEL_TRACES_SAMPLER.
+type Config struct {
+	// none still propagates traceparent headers, so traces started by nginx carry on through to outbound requests, they just aren't recorded
+	Exporter string `env:"OTEL_TRACES_EXPORTER" default:"none" oneof:"none|otlp|console"`
+}
+
+// Sets up the global tracer provider and W3C trace context propagation for the exporter cfg picks.
+// Returns a func that flushes any spans not yet exported and stops the exporter, to be called when shutting down.
+func Setup(ctx context.Context, cfg Config) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "console":
+		exporter, err = stdouttrace.New()
+	default:
+		err = errors.New("unknown OTEL_TRACES_EXPORTER " + cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx, resource.WithFromEnv(), resource.WithTelemetrySDK(), resource.WithProcessRuntimeName())
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// An http.Client whose requests get their own client spans, and carry the current trace on to whoever they're sent to.
+// A timeout of 0 means none, as with http.Client.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport), Timeout: timeout}
+}